@@ -0,0 +1,109 @@
+package grout
+
+import (
+	"fmt"
+)
+
+const (
+	defaultYearlyPath  = "/:year/"
+	defaultMonthlyPath = "/:year/:month/"
+)
+
+// archives builds yearly and monthly archives of a collection of posts
+// when the site config has an archives section:
+//
+//	archives:
+//	  collection: posts
+//	  layout: archive
+//	  yearly: /:year/
+//	  monthly: /:year/:month/
+//
+// The archives are put in data as a list of years, newest first, each
+// with its months. If a layout is set, a page is made for every year
+// and month, with a page object holding its period and posts.
+func (b *builder) archives(collections []collection, data M) []Content {
+	cfg := b.cfg.Map("archives")
+	if cfg == nil {
+		return nil
+	}
+	var col *collection
+	for i := range collections {
+		if collections[i].name == cfg.String("collection", "posts") {
+			col = &collections[i]
+		}
+	}
+	if col == nil {
+		return nil
+	}
+
+	site := NewSiteURL(b.cfg)
+	period := func(pattern, year, month, title string) M {
+		_, link := Permalink(pattern, map[string]string{
+			"year":  year,
+			"month": month,
+		})
+		return M{
+			"title": title,
+			"year":  year,
+			"month": month,
+			"url":   site.Rel(link),
+			"posts": []M{},
+		}
+	}
+
+	// Posts are sorted newest first, so periods are too.
+	var years []M
+	var year, month M
+	for _, c := range col.content {
+		p, ok := c.(*Post)
+		if !ok {
+			continue
+		}
+		y := p.datetime.Format("2006")
+		m := p.datetime.Format("01")
+		if year == nil || year["year"] != y {
+			year = period(cfg.String("yearly", defaultYearlyPath), y, "", y)
+			year["period"] = "year"
+			year["months"] = []M{}
+			years = append(years, year)
+			month = nil
+		}
+		if month == nil || month["month"] != m {
+			month = period(cfg.String("monthly", defaultMonthlyPath), y, m,
+				fmt.Sprintf("%s %s", p.datetime.Format("January"), y))
+			month["period"] = "month"
+			month["name"] = p.datetime.Format("January")
+			year["months"] = append(year["months"].([]M), month)
+		}
+		year["posts"] = append(year["posts"].([]M), p.Metadata())
+		month["posts"] = append(month["posts"].([]M), p.Metadata())
+	}
+	for _, y := range years {
+		y["count"] = len(y["posts"].([]M))
+		for _, m := range y["months"].([]M) {
+			m["count"] = len(m["posts"].([]M))
+		}
+	}
+	data["archives"] = years
+
+	layout := cfg.String("layout", "")
+	if layout == "" {
+		return nil
+	}
+	var pages []Content
+	for _, y := range years {
+		pages = append(pages, b.archivePage(cfg.String("yearly", defaultYearlyPath), layout, y))
+		for _, m := range y["months"].([]M) {
+			pages = append(pages, b.archivePage(cfg.String("monthly", defaultMonthlyPath), layout, m))
+		}
+	}
+	return pages
+}
+
+func (b *builder) archivePage(pattern, layout string, period M) Content {
+	file, _ := Permalink(pattern, map[string]string{
+		"year":  period["year"].(string),
+		"month": period["month"].(string),
+	})
+	return &layoutPage{path: file, layout: layout, page: period, templates: b.templates}
+}
@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 )
 
 type Collectable interface {
@@ -21,6 +22,19 @@ type collection struct {
 	generate Generator
 	config   M
 	content  []Content
+	workers  int
+
+	// drafts and future keep unpublished and future-dated items in
+	// the collection, for previewing.
+	drafts bool
+	future bool
+
+	// templates are the site's layouts and includes, given to every
+	// item
+	templates *siteTemplates
+	// typeName names the content type of files ending in an
+	// extension, for front matter defaults scoped by type
+	typeName func(ext string) string
 }
 
 // ErrIgnore is specially handled to allow generation to proceed
@@ -31,47 +45,52 @@ func (c *collection) Dir() string {
 		"_"+strings.ToLower(c.name))
 }
 
+// DraftsDir is where the collection's drafts are kept, or "" if it has
+// none. Only the posts collection has drafts unless drafts_dir is set.
+func (c *collection) DraftsDir() string {
+	def := ""
+	if c.name == "posts" {
+		def = "_drafts"
+	}
+	return c.config.String("drafts_dir", def)
+}
+
 func (c *collection) Read(dir string, sitecfg, tmplData M) error {
-	matches, err := filepath.Glob(filepath.Join(dir, c.Dir(), "*"))
+	content, err := c.generateDir(dir, c.Dir(), sitecfg, c.config)
 	if err != nil {
 		return err
 	}
-
-	dir = filepath.Join(dir, c.Dir())
-	// FIXME: Probably all be much cleaner if we could work
-	// with a []Collectable instead of a []Content.
-	content := make([]Content, 0, 8)
-	for _, m := range matches {
-		fileinfo, err := os.Stat(m)
+	if c.drafts && c.DraftsDir() != "" {
+		drafts, err := c.generateDir(dir, c.DraftsDir(),
+			sitecfg, c.config.With("draft", true))
 		if err != nil {
 			return err
 		}
-		if fileinfo.IsDir() {
-			continue
-		}
+		content = append(content, drafts...)
+	}
 
-		path, err := filepath.Rel(dir, m)
+	err = parallel(c.workers, len(content), func(i int) error {
+		err := content[i].Read(tmplData)
 		if err != nil {
-			return err
+			return c.itemError(content[i].Path(), err)
 		}
-
-		con, err := c.generate(sitecfg, c.config, ContentInfo{fileinfo, m, path})
-		if err != nil {
-			if err == ErrIgnore {
-				continue
-			}
-			return err
-		}
-		content = append(content, con)
+		return nil
+	})
+	if err != nil {
+		return err
 	}
-
+	content = c.published(content)
+	sort.Sort(ContentSlice(content))
+	var items []M
 	for _, con := range content {
-		err = con.Read(tmplData)
-		if err != nil {
-			return err
+		if col, ok := con.(Collectable); ok && col.Metadata() != nil {
+			items = append(items, col.Metadata())
 		}
 	}
-	sort.Sort(ContentSlice(content))
+	linkNeighbours(items)
+	if limit := c.config.Int("related_limit", 0); limit > 0 {
+		relate(items, limit)
+	}
 	for i, con := range content {
 		col, ok := con.(Collectable)
 		if !ok {
@@ -79,7 +98,7 @@ func (c *collection) Read(dir string, sitecfg, tmplData M) error {
 		}
 		err = col.PostRead(tmplData, content, i)
 		if err != nil {
-			return err
+			return c.itemError(con.Path(), err)
 		}
 	}
 
@@ -98,15 +117,138 @@ func (c *collection) Read(dir string, sitecfg, tmplData M) error {
 	return nil
 }
 
-func (c *collection) Write(dir, cachedir string, tmplData M) error {
-	var err error
+// linkNeighbours gives every item links to the items around it, in the
+// order of the collection: previous and next, first and last, and for
+// each of its tags, the previous and next items with the same tag:
+//
+//	{{with .page.neighbours.go}}{{.previous.title}}{{end}}
+func linkNeighbours(items []M) {
+	if len(items) == 0 {
+		return
+	}
+	first := itemLink(items[0])
+	last := itemLink(items[len(items)-1])
+	byTag := make(map[string][]int)
+	for i, item := range items {
+		if i > 0 {
+			item["previous"] = itemLink(items[i-1])
+		}
+		if i+1 < len(items) {
+			item["next"] = itemLink(items[i+1])
+		}
+		item["first"] = first
+		item["last"] = last
+		for _, t := range termsOf(item["tags"]) {
+			byTag[t] = append(byTag[t], i)
+		}
+	}
+
+	for tag, indexes := range byTag {
+		for n, i := range indexes {
+			nav := make(M, 2)
+			if n > 0 {
+				nav["previous"] = itemLink(items[indexes[n-1]])
+			}
+			if n+1 < len(indexes) {
+				nav["next"] = itemLink(items[indexes[n+1]])
+			}
+			neighbours, _ := items[i]["neighbours"].(M)
+			if neighbours == nil {
+				neighbours = make(M)
+				items[i]["neighbours"] = neighbours
+			}
+			neighbours[tag] = nav
+		}
+	}
+}
+
+// generateDir makes content from every file in the sub dir of the site
+// using the collection's generator, passing it cfg.
+func (c *collection) generateDir(site, sub string, sitecfg, cfg M) ([]Content, error) {
+	dir := filepath.Join(site, sub)
+	matches, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		return nil, err
+	}
+
+	// FIXME: Probably all be much cleaner if we could work
+	// with a []Collectable instead of a []Content.
+	content := make([]Content, 0, 8)
+	for _, m := range matches {
+		fileinfo, err := os.Stat(m)
+		if err != nil {
+			return nil, err
+		}
+		if fileinfo.IsDir() {
+			continue
+		}
+
+		path, err := filepath.Rel(dir, m)
+		if err != nil {
+			return nil, err
+		}
+
+		con, err := c.generate(sitecfg, cfg, ContentInfo{fileinfo, m, path})
+		if err != nil {
+			if err == ErrIgnore {
+				continue
+			}
+			return nil, c.itemError(path, err)
+		}
+		applyDefaults(sitecfg, con, scope{
+			path:       filepath.Join(sub, path),
+			collection: c.name,
+			typ:        c.typeName(filepath.Ext(path)),
+		})
+		c.templates.give(con)
+		content = append(content, con)
+	}
+	return content, nil
+}
+
+// published leaves out items with "published: false" in their front
+// matter and items dated in the future, unless the collection was
+// asked to keep them.
+func (c *collection) published(content []Content) []Content {
+	now := time.Now()
+	out := content[:0]
+	for _, con := range content {
+		if !c.drafts && !isPublished(con) {
+			continue
+		}
+		col, ok := con.(Collectable)
+		if !c.future && ok && itemTime(col.Metadata()).After(now) {
+			continue
+		}
+		out = append(out, con)
+	}
+	return out
+}
+
+// isPublished reports whether c's front matter, if it has any, doesn't
+// say "published: false".
+func isPublished(c Content) bool {
+	f, ok := c.(frontMattered)
+	return !ok || f.frontMatter().Bool("published", true)
+}
+
+func (c *collection) Write(dir, cachedir string, tmplData M, deps *depGraph) error {
 	fmt.Printf("Writing %s...\n", c.name)
-	for _, con := range c.content {
-		err = con.Write(dir, cachedir, tmplData)
+	err := parallel(c.workers, len(c.content), func(i int) error {
+		con := c.content[i]
+		err := deps.write(con, c, dir, cachedir, tmplData)
 		if err != nil {
-			return err
+			return c.itemError(con.Path(), err)
 		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 	fmt.Println("")
 	return nil
 }
+
+func (c *collection) itemError(path string, err error) error {
+	return &CollectionError{Collection: c.name, Path: path, Err: err}
+}
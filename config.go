@@ -1,5 +1,14 @@
 package grout
 
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
 var defaultConfig = M{
 	"url": "",
 	"collections": M{
@@ -9,3 +18,163 @@ var defaultConfig = M{
 		},
 	},
 }
+
+// configFiles are the names the site config may have, in the order
+// they are looked for. Only the first one found is read, unless
+// Options.Config lists the files to read.
+var configFiles = []string{"_config.yml", "_config.yaml", "_config.toml", "_config.json"}
+
+// envPrefix starts the names of environment variables that override
+// the site config.
+const envPrefix = "GROUT_"
+
+// readConfig builds the site config up in layers, each merged over the
+// last, so nested maps are combined rather than replaced:
+//
+//   - the default config
+//   - each of the config files, in order
+//   - the block for the build's environment, from the environments
+//     section of the config
+//   - environment variables such as GROUT_URL, with a double
+//     underscore between nested keys, as in GROUT_MARKDOWN__TABLES
+func (b *builder) readConfig(dir string) error {
+	m := defaultConfig
+	explicit := len(b.Config) > 0
+	paths := b.configPaths(dir)
+	b.cfgPath = paths[0]
+	var layers []configLayer
+	for _, path := range paths {
+		raw, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) && !explicit {
+			// if no config exists, just use the default one
+			continue
+		}
+		if err != nil {
+			return &ConfigError{File: path, Err: err}
+		}
+		b.cfgPath = path
+
+		ext := filepath.Ext(path)
+		decode, ok := dataDecoders[strings.ToLower(ext)]
+		if !ok {
+			return &ConfigError{File: path, Err: fmt.Errorf("unsupported config format %q", ext)}
+		}
+		v, err := decode(raw)
+		if err != nil {
+			return &ConfigError{File: path, Err: err}
+		}
+		cfg, ok := v.(M)
+		if !ok && v != nil {
+			return &ConfigError{File: path, Err: errors.New("config isn't a map")}
+		}
+		layers = append(layers, configLayer{file: path, raw: raw, cfg: cfg})
+		m = mergeMaps(m, cfg)
+		if !explicit {
+			break
+		}
+	}
+
+	env := b.env()
+	if env != "" {
+		// each file's block for the environment is a layer of its own,
+		// found under the environments section of that file
+		prefix := []string{"environments", env}
+		files := layers
+		for _, l := range files {
+			if block := l.cfg.Map("environments/" + env); block != nil {
+				layers = append(layers, configLayer{file: l.file, raw: l.raw, cfg: block, prefix: prefix})
+			}
+		}
+		m = mergeMaps(m, m.Map("environments/"+env))
+	}
+	over, err := envConfig(os.Environ(), m)
+	if err != nil {
+		return &ConfigError{File: "environment", Err: err}
+	}
+	layers = append(layers, configLayer{file: "environment", cfg: over})
+	m = mergeMaps(m, over)
+
+	err = checkConfig(layers, m, env).report()
+	if err != nil {
+		return err
+	}
+	b.cfg = m
+	return nil
+}
+
+// configPaths returns the config files to read, which are relative to
+// the site.
+func (b *builder) configPaths(dir string) []string {
+	names := b.Config
+	if len(names) == 0 {
+		names = configFiles
+	}
+	paths := make([]string, len(names))
+	for i, name := range names {
+		paths[i] = name
+		if !filepath.IsAbs(name) {
+			paths[i] = filepath.Join(dir, name)
+		}
+	}
+	return paths
+}
+
+// env returns the name of the environment the site is built for, from
+// Options.Env or else the GROUT_ENV variable.
+func (b *builder) env() string {
+	if b.Env != "" {
+		return b.Env
+	}
+	return os.Getenv(envPrefix + "ENV")
+}
+
+// envConfig makes config from the GROUT_ variables in environ, other
+// than GROUT_ENV, over the config cfg read before them. Values are
+// strings, except for keys grout reads as something else, whose values
+// are read as YAML: GROUT_SUMMARY_WORDS=50 is a number and
+// GROUT_SITEMAP=false a bool, but GROUT_TITLE=yes is still a string.
+func envConfig(environ []string, cfg M) (M, error) {
+	m := make(M)
+	for _, kv := range environ {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], envPrefix) ||
+			parts[0] == envPrefix+"ENV" {
+			continue
+		}
+		keys := strings.Split(strings.ToLower(parts[0][len(envPrefix):]), "__")
+		var v interface{} = parts[1]
+		if s := schemaAt(keys, cfg); s != nil && s.kinds != "" && s.kinds != "string" {
+			var err error
+			v, err = decodeYAML([]byte(parts[1]))
+			if err != nil {
+				return nil, err
+			}
+		}
+		over := M{keys[len(keys)-1]: v}
+		for i := len(keys) - 2; i >= 0; i-- {
+			over = M{keys[i]: over}
+		}
+		m = mergeMaps(m, over)
+	}
+	return m, nil
+}
+
+// mergeMaps returns base with over merged into it. Where both have a
+// map under the same key, those are merged in turn; otherwise values in
+// over win. Neither base nor over is changed.
+func mergeMaps(base, over M) M {
+	m := make(M, len(base)+len(over))
+	for k, v := range base {
+		m[k] = v
+	}
+	for k, v := range over {
+		bm, bok := m[k].(M)
+		om, ook := v.(M)
+		if bok && ook {
+			m[k] = mergeMaps(bm, om)
+			continue
+		}
+		m[k] = v
+	}
+	return m
+}
@@ -1,7 +1,12 @@
 package grout
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/james4k/fmatter"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 )
@@ -95,3 +100,76 @@ func (f File) Write(dir, cachedir string, data M) error {
 	io.Copy(newf, oldf)
 	return nil
 }
+
+// readFrontMatter reads the file at path, decoding its front matter
+// into fm. Front matter is YAML between "---" lines, TOML between "+++"
+// lines, or a JSON object at the very start of the file, except in
+// .json files, where that object is the content itself. It returns the
+// remaining content along with the number of lines the front matter
+// took up.
+func readFrontMatter(path string, fm M) ([]byte, int, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	var content []byte
+	switch {
+	case bytes.HasPrefix(raw, []byte("+++\n")), bytes.HasPrefix(raw, []byte("+++\r\n")):
+		content, err = readTOMLFrontMatter(raw, fm)
+	case bytes.HasPrefix(raw, []byte("{")) && !bytes.HasPrefix(raw, []byte("{{")) &&
+		filepath.Ext(path) != ".json":
+		content, err = readJSONFrontMatter(raw, fm)
+	default:
+		content, err = fmatter.Read(raw, fm)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	offset := bytes.Count(raw, []byte("\n")) - bytes.Count(content, []byte("\n"))
+	return content, offset, nil
+}
+
+func readTOMLFrontMatter(raw []byte, fm M) ([]byte, error) {
+	// rest starts at the newline ending the opening +++, so the closing
+	// one is found even when the front matter is empty
+	rest := raw[bytes.IndexByte(raw, '\n'):]
+	end := bytes.Index(rest, []byte("\n+++"))
+	if end < 0 {
+		return nil, errors.New("front matter has no closing +++")
+	}
+	v, err := decodeTOML(rest[1 : end+1])
+	if err != nil {
+		return nil, err
+	}
+	m, _ := v.(M)
+	for k, val := range m {
+		fm[k] = val
+	}
+	return skipLine(rest[end+len("\n+++"):]), nil
+}
+
+func readJSONFrontMatter(raw []byte, fm M) ([]byte, error) {
+	var v map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	err := dec.Decode(&v)
+	if err != nil {
+		return nil, err
+	}
+	for k, val := range sanitizeValue(v).(M) {
+		fm[k] = val
+	}
+	return skipLine(raw[dec.InputOffset():]), nil
+}
+
+// skipLine returns b after the end of its first line, if that line is
+// blank.
+func skipLine(b []byte) []byte {
+	line := b
+	if i := bytes.IndexByte(b, '\n'); i >= 0 {
+		line = b[:i+1]
+	}
+	if len(bytes.TrimSpace(line)) == 0 {
+		return b[len(line):]
+	}
+	return b
+}
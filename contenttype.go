@@ -0,0 +1,104 @@
+package grout
+
+import (
+	"log"
+)
+
+// ContentType makes the Content for a file with a particular extension.
+// It may return ErrIgnore to leave the file out of the site.
+type ContentType func(sitecfg M, info ContentInfo) (Content, error)
+
+var contentTypes = make(map[string]ContentType)
+
+// RegisterContentType makes files ending in ext, such as ".json", be
+// read and written through ct. It takes precedence over the built-in
+// types, but not over the site config's content_types section.
+func RegisterContentType(ext string, ct ContentType) {
+	if _, ok := contentTypes[ext]; ok {
+		log.Fatalf("Content type for '%s' already exists!\n", ext)
+	}
+	contentTypes[ext] = ct
+}
+
+// builtinTypes are the content types that the content_types section
+// of the site config can map extensions to.
+var builtinTypes = map[string]ContentType{
+	"html":     NewHTMLDocument,
+	"markdown": NewMarkdownDocument,
+	"text":     NewTextDocument,
+	"file":     NewFile,
+	"ignore":   ignoreContent,
+}
+
+// builtinExts are used for extensions nothing else claims.
+var builtinExts = map[string]string{
+	".go":       "ignore",
+	".html":     "html",
+	".htm":      "html",
+	".md":       "markdown",
+	".markdown": "markdown",
+	".xml":      "text",
+	".css":      "text",
+}
+
+// contentType picks the content type for files ending in ext. The
+// content_types section of the site config maps extensions to the
+// names of built-in types, for example:
+//
+//	content_types:
+//	  .json: text
+//	  .psd: ignore
+func (b *builder) contentType(ext string) ContentType {
+	if name := b.cfg.Map("content_types").String(ext, ""); name != "" {
+		if ct, ok := builtinTypes[name]; ok {
+			return ct
+		}
+	}
+	if ct, ok := contentTypes[ext]; ok {
+		return ct
+	}
+	if ct, ok := builtinTypes[builtinExts[ext]]; ok {
+		return ct
+	}
+	return NewFile
+}
+
+// contentTypeName returns the name of the built-in type files ending in
+// ext are, or "" if they are a registered type.
+func (b *builder) contentTypeName(ext string) string {
+	if name := b.cfg.Map("content_types").String(ext, ""); name != "" {
+		if _, ok := builtinTypes[name]; ok {
+			return name
+		}
+	}
+	if _, ok := contentTypes[ext]; ok {
+		return ""
+	}
+	if name, ok := builtinExts[ext]; ok {
+		return name
+	}
+	return "file"
+}
+
+func NewHTMLDocument(sitecfg M, info ContentInfo) (Content, error) {
+	return &HTMLDocument{ContentInfo: info}, nil
+}
+
+// NewMarkdownDocument makes an HTMLDocument converted from Markdown,
+// written out with an .html extension.
+func NewMarkdownDocument(sitecfg M, info ContentInfo) (Content, error) {
+	info.SetPath(replaceExt(info.Path(), ".html"))
+	return &HTMLDocument{ContentInfo: info, Markdown: true}, nil
+}
+
+func NewTextDocument(sitecfg M, info ContentInfo) (Content, error) {
+	return &TextDocument{ContentInfo: info}, nil
+}
+
+func NewFile(sitecfg M, info ContentInfo) (Content, error) {
+	return File{ContentInfo: info}, nil
+}
+
+func ignoreContent(sitecfg M, info ContentInfo) (Content, error) {
+	return nil, ErrIgnore
+}
@@ -0,0 +1,124 @@
+package grout
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"io/ioutil"
+	"launchpad.net/goyaml"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dataDecoders decode the files in _data by extension.
+var dataDecoders = map[string]func([]byte) (interface{}, error){
+	".yml":  decodeYAML,
+	".yaml": decodeYAML,
+	".json": decodeJSON,
+	".toml": decodeTOML,
+	".csv":  decodeCSV,
+}
+
+// readData loads every data file under the _data dir of the site into
+// a map, keyed by file name without the extension. Subdirectories
+// become nested maps, so _data/team/authors.yml is .data.team.authors
+// in templates. Files of other types are left out.
+func readData(dir string) (M, error) {
+	root := filepath.Join(dir, "_data")
+	data := make(M)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == root {
+				return nil
+			}
+			return err
+		}
+		name := info.Name()
+		if name[0] == '.' {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		decode, ok := dataDecoders[strings.ToLower(filepath.Ext(name))]
+		if info.IsDir() || !ok {
+			return nil
+		}
+
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		v, err := decode(raw)
+		if err != nil {
+			return &ConfigError{File: path, Err: err}
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		keys := strings.Split(filepath.ToSlash(replaceExt(rel, "")), "/")
+		defined := &ConfigError{File: path,
+			Err: fmt.Errorf("data %q is already defined", strings.Join(keys, "."))}
+		m := data
+		for _, k := range keys[:len(keys)-1] {
+			if m[k] == nil {
+				m[k] = make(M)
+			}
+			sub, ok := m[k].(M)
+			if !ok {
+				return defined
+			}
+			m = sub
+		}
+		key := keys[len(keys)-1]
+		if _, ok := m[key]; ok {
+			return defined
+		}
+		m[key] = v
+		return nil
+	})
+	return data, err
+}
+
+func decodeYAML(raw []byte) (interface{}, error) {
+	var v interface{}
+	err := goyaml.Unmarshal(raw, &v)
+	return sanitizeValue(v), err
+}
+
+func decodeJSON(raw []byte) (interface{}, error) {
+	var v interface{}
+	err := json.Unmarshal(raw, &v)
+	return sanitizeValue(v), err
+}
+
+func decodeTOML(raw []byte) (interface{}, error) {
+	var v map[string]interface{}
+	_, err := toml.Decode(string(raw), &v)
+	return sanitizeValue(v), err
+}
+
+// decodeCSV makes a list of rows, each a map keyed by the column names
+// in the first row.
+func decodeCSV(raw []byte) (interface{}, error) {
+	records, err := csv.NewReader(strings.NewReader(string(raw))).ReadAll()
+	if err != nil || len(records) == 0 {
+		return []M{}, err
+	}
+	header := records[0]
+	rows := make([]M, 0, len(records)-1)
+	for _, rec := range records[1:] {
+		row := make(M, len(header))
+		for i, col := range header {
+			if i < len(rec) {
+				row[col] = rec[i]
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
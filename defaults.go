@@ -0,0 +1,99 @@
+package grout
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// scope says where a file sits in the site, for picking which front
+// matter defaults apply to it.
+type scope struct {
+	path       string // relative to the site, such as "_posts/a.md"
+	collection string // "" for files outside of collections
+	typ        string // content type name, such as "markdown"
+}
+
+// defaultable is content whose front matter can have defaults, such as
+// an HTMLDocument or anything embedding one.
+type defaultable interface {
+	setDefaults(M)
+}
+
+func (d *HTMLDocument) setDefaults(m M) {
+	d.Defaults = m
+}
+
+func (d *TextDocument) setDefaults(m M) {
+	d.Defaults = m
+}
+
+// mergeDefaults sets every value of defaults that fm doesn't have.
+func mergeDefaults(fm, defaults M) {
+	for k, v := range defaults {
+		if _, ok := fm[k]; !ok {
+			fm[k] = v
+		}
+	}
+}
+
+// applyDefaults gives c the front matter defaults from the site config
+// that apply to it. Each entry of the defaults section has a scope and
+// the values it sets:
+//
+//	defaults:
+//	  - scope:
+//	      collection: posts
+//	    values:
+//	      layout: post
+//	  - scope:
+//	      path: "docs/*.md"
+//	      type: markdown
+//	    values:
+//	      layout: doc
+//
+// A scope may have a path glob, or a dir that everything under it
+// matches, a collection name and a content type name, all of which must
+// match. When more than one entry sets a value, the later one wins.
+func applyDefaults(sitecfg M, c Content, s scope) {
+	d, ok := c.(defaultable)
+	if !ok {
+		return
+	}
+	entries, _ := sitecfg["defaults"].([]interface{})
+	var values M
+	for _, e := range entries {
+		entry, _ := e.(M)
+		if !inScope(entry.Map("scope"), s) {
+			continue
+		}
+		for k, v := range entry.Map("values") {
+			if values == nil {
+				values = make(M, 8)
+			}
+			values[k] = v
+		}
+	}
+	if values != nil {
+		d.setDefaults(values)
+	}
+}
+
+// inScope reports whether s is within the scope m of a defaults entry.
+func inScope(m M, s scope) bool {
+	if c := m.String("collection", ""); c != "" && c != s.collection {
+		return false
+	}
+	if t := m.String("type", ""); t != "" && t != s.typ {
+		return false
+	}
+	pattern := strings.Trim(m.String("path", ""), "/")
+	if pattern == "" {
+		return true
+	}
+	p := filepath.ToSlash(s.path)
+	if ok, _ := path.Match(pattern, p); ok {
+		return true
+	}
+	return strings.HasPrefix(p, pattern+"/")
+}
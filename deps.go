@@ -0,0 +1,452 @@
+package grout
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"text/template/parse"
+)
+
+// Dependent can be implemented by Content whose Write reads or writes
+// files beyond FullPath and Path, so incremental builds know when it
+// must be written again and what to carry over when it needn't be.
+type Dependent interface {
+	// Inputs returns the full paths of extra files read by Write.
+	Inputs() []string
+	// Outputs returns extra paths written by Write, relative to the
+	// output dir.
+	Outputs() []string
+}
+
+// templated is implemented by content rendered through templates, and
+// lets the dependency graph see which data keys and layouts it uses.
+type templated interface {
+	templateTrees() []*parse.Tree
+	layoutName() string
+	// extraDataKeys returns data keys used other than through the
+	// templates themselves.
+	extraDataKeys() []string
+}
+
+// allData is the data key used when a template passes along dot as a
+// whole, making it depend on everything.
+const allData = "*"
+
+// buildTimeKeys are the data keys that change with every build. Only
+// templates naming them depend on them, not those passing along dot.
+var buildTimeKeys = []string{"time", "xmltime"}
+
+// includeDeps is the data key used when a template calls include,
+// making it depend on the includes and the data keys they use.
+const includeDeps = "include()"
+
+const manifestName = "deps.json"
+
+// manifest is what a build leaves in deps.json.
+type manifest struct {
+	// Build is the buildID of the program that wrote the pages. None
+	// are carried over into a build by a different one.
+	Build string                       `json:"build"`
+	Pages map[string]map[string]string `json:"pages"`
+}
+
+// depGraph records what every templated output depends on, so a later
+// build can carry over outputs whose inputs haven't changed instead of
+// rendering them again.
+type depGraph struct {
+	dir     string
+	layouts map[string]*layoutDeps
+	prev    map[string]map[string]string
+	// includes is a hash of the sources of every include, and
+	// includeKeys the data keys they use
+	includes    string
+	includeKeys []string
+
+	// guards everything below, as pages are written concurrently
+	mu      sync.Mutex
+	hashes  map[string]string
+	next    map[string]map[string]string
+	skipped int
+}
+
+type layoutDeps struct {
+	path   string
+	parent string
+	keys   []string
+}
+
+// cacheDir returns where build caches for input are kept.
+func cacheDir(input string, opt *Options) string {
+	if opt.CacheDir != "" {
+		return opt.CacheDir
+	}
+	return filepath.Join(input, "_cache")
+}
+
+// newDepGraph loads the manifest from the last build out of dir. If
+// clean is set, the manifest is ignored and everything is rendered.
+func newDepGraph(dir string, templates *siteTemplates, clean bool) *depGraph {
+	g := &depGraph{
+		dir:      dir,
+		layouts:  make(map[string]*layoutDeps),
+		includes: hashValue(templates.includes),
+		hashes:   make(map[string]string),
+		prev:     make(map[string]map[string]string),
+		next:     make(map[string]map[string]string),
+	}
+	if !clean {
+		var m manifest
+		raw, err := ioutil.ReadFile(filepath.Join(dir, manifestName))
+		if err == nil && json.Unmarshal(raw, &m) == nil && m.Build == buildID() {
+			g.prev = m.Pages
+		}
+	}
+
+	for _, t := range templates.textIncludes.Templates() {
+		if t.Tree != nil {
+			g.includeKeys = dataKeys(g.includeKeys, t.Tree.Root)
+		}
+	}
+	for name, l := range templates.layouts {
+		var keys []string
+		for _, t := range l.template.Templates() {
+			keys = dataKeys(keys, t.Tree.Root)
+		}
+		g.layouts[name] = &layoutDeps{path: l.path, parent: l.parent, keys: keys}
+	}
+	return g
+}
+
+// save writes the manifest for the build that just finished.
+func (g *depGraph) save() error {
+	raw, err := json.Marshal(manifest{Build: buildID(), Pages: g.next})
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(g.dir, 0700)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(g.dir, manifestName), raw, 0600)
+}
+
+var (
+	exeHashOnce sync.Once
+	exeHash     string
+)
+
+// buildID identifies the program building the site by a hash of its
+// executable, so that upgrading grout, or whatever embeds it, renders
+// every page again. It also covers the registered generators, content
+// types and template functions, which a program may change from one
+// build to the next.
+func buildID() string {
+	exeHashOnce.Do(func() {
+		exe, err := os.Executable()
+		if err == nil {
+			exeHash = hashFile(exe)
+		}
+	})
+	names := []string{exeHash}
+	for k, fn := range generators {
+		names = append(names, "generator "+k+" "+funcName(fn))
+	}
+	for k, fn := range contentTypes {
+		names = append(names, "type "+k+" "+funcName(fn))
+	}
+	for k, fn := range registeredFuncs {
+		names = append(names, "func "+k+" "+funcName(fn))
+	}
+	sort.Strings(names)
+	return hashValue(names)
+}
+
+// funcName returns the name fn was declared with, or "" if it isn't a
+// function.
+func funcName(fn interface{}) string {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func {
+		return ""
+	}
+	return runtime.FuncForPC(v.Pointer()).Name()
+}
+
+// write writes c into dir, unless everything it depends on is the same
+// as in the last build, in which case its outputs are copied over from
+// cachedir. col is the collection c belongs to, if any.
+func (g *depGraph) write(c Content, col *collection, dir, cachedir string, data M) error {
+	deps := g.dependencies(c, col, data)
+	if deps == nil {
+		return c.Write(dir, cachedir, data)
+	}
+
+	outputs := []string{c.Path()}
+	if d, ok := c.(Dependent); ok {
+		outputs = append(outputs, d.Outputs()...)
+	}
+	if sameDeps(g.prev[c.Path()], deps) &&
+		copyOutputs(outputs, dir, cachedir) == nil {
+		g.record(c.Path(), deps, true)
+		return nil
+	}
+
+	err := c.Write(dir, cachedir, data)
+	if err != nil {
+		return err
+	}
+	g.record(c.Path(), deps, false)
+	return nil
+}
+
+func (g *depGraph) record(path string, deps map[string]string, skipped bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.next[path] = deps
+	if skipped {
+		g.skipped++
+	}
+}
+
+// dependencies returns a fingerprint for every input c depends on, or
+// nil if c can't take part in incremental builds.
+func (g *depGraph) dependencies(c Content, col *collection, data M) map[string]string {
+	t, ok := c.(templated)
+	if !ok {
+		return nil
+	}
+
+	deps := make(map[string]string, 8)
+	files := []string{c.FullPath()}
+	if d, ok := c.(Dependent); ok {
+		files = append(files, d.Inputs()...)
+	}
+	for _, f := range files {
+		deps["file:"+f] = g.fileHash(f)
+	}
+
+	keys := t.extraDataKeys()
+	for _, tree := range t.templateTrees() {
+		keys = dataKeys(keys, tree.Root)
+	}
+	seen := make(map[string]bool)
+	for name := t.layoutName(); name != "" && !seen[name]; {
+		seen[name] = true
+		l, ok := g.layouts[name]
+		if !ok {
+			// let Write report the missing layout
+			return nil
+		}
+		deps["layout:"+name] = g.fileHash(l.path)
+		keys = append(keys, l.keys...)
+		name = l.parent
+	}
+
+	for _, k := range keys {
+		if k == includeDeps {
+			deps["includes"] = g.includes
+			keys = append(keys, g.includeKeys...)
+			break
+		}
+	}
+	for _, k := range keys {
+		if k == includeDeps {
+			continue
+		}
+		if k == "page" {
+			// front matter is covered by the file itself
+			continue
+		}
+		deps["data:"+k] = g.dataHash(k, data)
+	}
+	if col != nil {
+		deps["collection:"+col.name] = hashValue(col.config)
+	}
+	if m, ok := c.(Collectable); ok {
+		deps["metadata"] = hashValue(m.Metadata())
+	}
+	return deps
+}
+
+func (g *depGraph) fileHash(path string) string {
+	key := "file:" + path
+	if h, ok := g.cachedHash(key); ok {
+		return h
+	}
+	h := hashFile(path)
+	g.cacheHash(key, h)
+	return h
+}
+
+// hashFile returns a hash of the file at path, or "" if it can't be
+// read.
+func hashFile(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	sum := sha1.New()
+	io.Copy(sum, f)
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+func (g *depGraph) dataHash(k string, data M) string {
+	key := "data:" + k
+	if h, ok := g.cachedHash(key); ok {
+		return h
+	}
+	var h string
+	if k == allData {
+		rest := make(M, len(data))
+		for k, v := range data {
+			rest[k] = v
+		}
+		for _, k := range buildTimeKeys {
+			delete(rest, k)
+		}
+		h = hashValue(rest)
+	} else {
+		h = hashValue(data[k])
+	}
+	g.cacheHash(key, h)
+	return h
+}
+
+func (g *depGraph) cachedHash(key string) (string, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	h, ok := g.hashes[key]
+	return h, ok
+}
+
+func (g *depGraph) cacheHash(key, h string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.hashes[key] = h
+}
+
+func hashValue(v interface{}) string {
+	sum := sha1.Sum([]byte(fmt.Sprintf("%v", v)))
+	return hex.EncodeToString(sum[:])
+}
+
+func sameDeps(a, b map[string]string) bool {
+	if a == nil || len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func copyOutputs(outputs []string, dir, cachedir string) error {
+	for _, p := range outputs {
+		in, err := os.Open(filepath.Join(cachedir, p))
+		if err != nil {
+			return err
+		}
+		outpath := filepath.Join(dir, p)
+		err = os.MkdirAll(filepath.Dir(outpath), 0700)
+		if err != nil {
+			in.Close()
+			return err
+		}
+		out, err := os.Create(outpath)
+		if err != nil {
+			in.Close()
+			return err
+		}
+		_, err = io.Copy(out, in)
+		in.Close()
+		out.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dataKeys appends the top-level data keys referenced under node to
+// keys. Fields are collected whatever dot is at that point, which may
+// add keys that aren't really used, but never misses one.
+func dataKeys(keys []string, node parse.Node) []string {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return keys
+		}
+		for _, c := range n.Nodes {
+			keys = dataKeys(keys, c)
+		}
+	case *parse.ActionNode:
+		keys = dataKeys(keys, n.Pipe)
+	case *parse.PipeNode:
+		if n == nil {
+			return keys
+		}
+		for _, c := range n.Cmds {
+			keys = dataKeys(keys, c)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			keys = dataKeys(keys, arg)
+		}
+	case *parse.FieldNode:
+		keys = append(keys, n.Ident[0])
+	case *parse.IdentifierNode:
+		keys = append(keys, funcDataKeys[n.Ident]...)
+	case *parse.ChainNode:
+		keys = dataKeys(keys, n.Node)
+	case *parse.VariableNode:
+		if n.Ident[0] == "$" {
+			if len(n.Ident) > 1 {
+				keys = append(keys, n.Ident[1])
+			} else {
+				keys = append(keys, allData)
+			}
+		}
+	case *parse.DotNode:
+		keys = append(keys, allData)
+	case *parse.IfNode:
+		keys = branchKeys(keys, &n.BranchNode)
+	case *parse.RangeNode:
+		keys = branchKeys(keys, &n.BranchNode)
+	case *parse.WithNode:
+		keys = branchKeys(keys, &n.BranchNode)
+	case *parse.TemplateNode:
+		// the named template may be one we can't see, so assume it
+		// uses whatever it was passed
+		if n.Pipe != nil {
+			keys = append(keys, allData)
+		}
+	}
+	return keys
+}
+
+func branchKeys(keys []string, n *parse.BranchNode) []string {
+	keys = dataKeys(keys, n.Pipe)
+	keys = dataKeys(keys, n.List)
+	return dataKeys(keys, n.ElseList)
+}
+
+// isWithin reports whether path is dir or inside it.
+func isWithin(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,133 @@
+package grout
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ConfigError reports a problem reading, decoding or checking the site
+// config. Line is 0 when the position is unknown.
+type ConfigError struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (e *ConfigError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("config %s:%d: %v", e.File, e.Line, e.Err)
+	}
+	return fmt.Sprintf("config %s: %v", e.File, e.Err)
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// TemplateError reports a template that failed to parse or execute.
+// Line is 0 when the position is unknown.
+type TemplateError struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (e *TemplateError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("template %s:%d: %v", e.File, e.Line, e.Err)
+	}
+	return fmt.Sprintf("template %s: %v", e.File, e.Err)
+}
+
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+// CollectionError reports a failure while reading or writing an item
+// of a collection. Path is empty when the failure isn't tied to a
+// single item.
+type CollectionError struct {
+	Collection string
+	Path       string
+	Err        error
+}
+
+func (e *CollectionError) Error() string {
+	if e.Path != "" {
+		return fmt.Sprintf("collection %s: %s: %v", e.Collection, e.Path, e.Err)
+	}
+	return fmt.Sprintf("collection %s: %v", e.Collection, e.Err)
+}
+
+func (e *CollectionError) Unwrap() error {
+	return e.Err
+}
+
+// ContentError reports a failure while reading or writing a regular
+// (non-collection) piece of content.
+type ContentError struct {
+	Op   string
+	Path string
+	Err  error
+}
+
+func (e *ContentError) Error() string {
+	return fmt.Sprintf("%s %s: %v", e.Op, e.Path, e.Err)
+}
+
+func (e *ContentError) Unwrap() error {
+	return e.Err
+}
+
+// template errors are formatted as "template: name:line: msg", or
+// "html/template:name:line: msg" for escaping errors
+var templateLineRE = regexp.MustCompile(`^(?:html/)?template: ?([^:]*):([0-9]+):`)
+
+// NewTemplateError wraps a text/template or html/template error as a
+// *TemplateError, pulling the line number out of its message.
+func NewTemplateError(file string, err error) error {
+	return templateError(file, "", 0, err)
+}
+
+// templateError is like NewTemplateError, but shifts the line by offset
+// when the error comes from the template called name. This accounts
+// for front matter stripped before parsing.
+func templateError(file, name string, offset int, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*TemplateError); ok {
+		return err
+	}
+	line := 0
+	if m := templateLineRE.FindStringSubmatch(err.Error()); m != nil {
+		line, _ = strconv.Atoi(m[2])
+		if m[1] == name {
+			line += offset
+		}
+	}
+	// the include chain says more than how the page called it
+	var inc *IncludeError
+	if errors.As(err, &inc) {
+		err = inc
+	}
+	return &TemplateError{File: file, Line: line, Err: err}
+}
+
+// IncludeError reports a failure inside a template from _includes.
+// Chain lists the includes that led to it, outermost first.
+type IncludeError struct {
+	Chain []string
+	Err   error
+}
+
+func (e *IncludeError) Error() string {
+	return fmt.Sprintf("include %s: %v", strings.Join(e.Chain, " > "), e.Err)
+}
+
+func (e *IncludeError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,50 @@
+package grout
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+const (
+	// defaultExcerptSeparator marks the end of a post's excerpt when
+	// the collection or site config doesn't set excerpt_separator.
+	defaultExcerptSeparator = "<!--more-->"
+	// defaultSummaryWords is how long a summary is when the config
+	// doesn't set summary_words.
+	defaultSummaryWords = 50
+)
+
+// excerpt returns the start of the rendered content: everything before
+// sep if the content has it, otherwise the first paragraph.
+func excerpt(content, sep string) string {
+	if i := strings.Index(content, sep); sep != "" && i >= 0 {
+		return strings.TrimSpace(content[:i])
+	}
+	if i := strings.Index(content, "</p>"); i >= 0 {
+		return strings.TrimSpace(content[:i+len("</p>")])
+	}
+	if i := strings.Index(content, "\n\n"); i >= 0 {
+		return strings.TrimSpace(content[:i])
+	}
+	return strings.TrimSpace(content)
+}
+
+var tagRE = regexp.MustCompile(`<[^>]*>`)
+
+// stripHTML returns the text of s with its tags removed, entities
+// decoded and runs of whitespace collapsed to a single space.
+func stripHTML(s string) string {
+	s = html.UnescapeString(tagRE.ReplaceAllString(s, " "))
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// truncateWords returns the first n words of s, followed by an ellipsis
+// if anything was cut off.
+func truncateWords(s string, n int) string {
+	words := strings.Fields(s)
+	if len(words) <= n {
+		return strings.Join(words, " ")
+	}
+	return strings.Join(words[:n], " ") + "…"
+}
@@ -0,0 +1,380 @@
+package grout
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const defaultFeedLimit = 20
+
+// feeds makes a feed for every entry in the feeds section of the site
+// config. A feed lists either a collection, or with taxonomy set, every
+// term of a taxonomy gets a feed of its own:
+//
+//	feeds:
+//	  atom:
+//	    collection: posts
+//	    path: /atom.xml
+//	  rss:
+//	    collection: posts
+//	    format: rss
+//	    path: /rss.xml
+//	    limit: 10
+//	  tags:
+//	    taxonomy: tags
+//	    path: /tags/:term/atom.xml
+//
+// format is one of atom (the default), rss or json. A feed is left out
+// when a file among content, such as a hand-written atom.xml, is
+// already at its path. It must run after collections and taxonomies
+// are read.
+func (b *builder) feeds(content []Content, data M) ([]Content, error) {
+	cfg := b.cfg.Map("feeds")
+	if cfg == nil {
+		return nil, nil
+	}
+
+	site := NewSiteURL(b.cfg)
+	author := feedAuthor(b.cfg)
+	exists := make(map[string]bool, len(content))
+	for _, c := range content {
+		exists[filepath.ToSlash(c.Path())] = true
+	}
+	var feeds []Content
+	add := func(f *feed) {
+		if !exists[f.path] {
+			feeds = append(feeds, f)
+		}
+	}
+	for name, iprops := range cfg {
+		props, _ := iprops.(M)
+		format := props.String("format", "atom")
+		if format != "atom" && format != "rss" && format != "json" {
+			return nil, &ConfigError{
+				File: b.cfgPath,
+				Err:  fmt.Errorf("feed %s: unknown format %q", name, format),
+			}
+		}
+		limit := props.Int("limit", defaultFeedLimit)
+		pattern := props.String("path", "/"+name+".xml")
+		title := props.String("title", b.cfg.String("title", ""))
+
+		newFeed := func(vars map[string]string, title string, items []M) *feed {
+			file, link := Permalink(pattern, vars)
+			if limit > 0 && len(items) > limit {
+				items = items[:limit]
+			}
+			return &feed{
+				path:        file,
+				format:      format,
+				title:       title,
+				description: props.String("description", b.cfg.String("description", "")),
+				author:      author,
+				link:        site.Abs("/"),
+				self:        site.Abs(link),
+				items:       items,
+			}
+		}
+
+		if taxonomy := props.String("taxonomy", ""); taxonomy != "" {
+			terms, _ := data[taxonomy].(M)
+			for term, it := range terms {
+				t, _ := it.(M)
+				items, _ := t["items"].([]M)
+				add(newFeed(map[string]string{
+					"taxonomy": taxonomy,
+					"term":     Slugify(term),
+				}, fmt.Sprintf("%s: %s", title, term), items))
+			}
+			continue
+		}
+		items, _ := data[props.String("collection", "posts")].([]M)
+		add(newFeed(nil, title, items))
+	}
+	return feeds, nil
+}
+
+// feedAuthor returns the name of the site's author, which is either
+// the author in the site config or its name, falling back to the site
+// title and then its url, since Atom feeds must have an author.
+func feedAuthor(sitecfg M) string {
+	for _, k := range []string{"author", "author/name", "title", "url"} {
+		if s := sitecfg.String(k, ""); s != "" {
+			return s
+		}
+	}
+	return "unknown"
+}
+
+// feed is a generated Atom, RSS or JSON feed of collection items.
+type feed struct {
+	path        string
+	format      string
+	title       string
+	description string
+	author      string
+	link        string
+	self        string
+	items       []M
+}
+
+func (f *feed) IsDir() bool {
+	return false
+}
+
+func (f *feed) FullPath() string {
+	return ""
+}
+
+func (f *feed) Path() string {
+	return f.path
+}
+
+func (f *feed) Read(data M) error {
+	return nil
+}
+
+func (f *feed) Write(dir, cachedir string, data M) error {
+	path := filepath.Join(dir, f.path)
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	switch f.format {
+	case "rss":
+		return f.writeRSS(out)
+	case "json":
+		return f.writeJSON(out)
+	}
+	return f.writeAtom(out)
+}
+
+// updated returns when the newest item was last changed, or now if
+// there are no dated items.
+func (f *feed) updated() time.Time {
+	var t time.Time
+	for _, item := range f.items {
+		if d := itemTime(item); d.After(t) {
+			t = d
+		}
+	}
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return t
+}
+
+// itemTime returns the date of a collection item, from its xmldate or
+// date metadata.
+func itemTime(item M) time.Time {
+	if t, err := time.Parse(time.RFC3339, item.String("xmldate", "")); err == nil {
+		return t
+	}
+	if t, err := time.Parse("2006-01-02", item.String("date", "")); err == nil {
+		return t
+	}
+	return time.Time{}
+}
+
+// itemSummary returns the short form of a collection item, if it has
+// one.
+func itemSummary(item M) string {
+	for _, k := range []string{"excerpt", "summary", "description"} {
+		if s := item.String(k, ""); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+func itemID(item M) string {
+	return item.String("atomid", item.String("absurl", item.String("url", "")))
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  *atomAuthor `xml:"author,omitempty"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Title   string    `xml:"title"`
+	ID      string    `xml:"id"`
+	Updated string    `xml:"updated"`
+	Link    atomLink  `xml:"link"`
+	Summary *atomText `xml:"summary,omitempty"`
+	Content *atomText `xml:"content,omitempty"`
+}
+
+func (f *feed) writeAtom(w io.Writer) error {
+	updated := f.updated()
+	doc := atomFeed{
+		Title:   f.title,
+		ID:      f.self,
+		Updated: XMLDate(updated),
+		Links: []atomLink{
+			{Href: f.self, Rel: "self"},
+			{Href: f.link},
+		},
+		Author: &atomAuthor{Name: f.author},
+	}
+	for _, item := range f.items {
+		entry := atomEntry{
+			Title:   item.String("title", ""),
+			ID:      itemID(item),
+			Updated: XMLDate(updated),
+			Link:    atomLink{Href: item.String("absurl", item.String("url", ""))},
+		}
+		if t := itemTime(item); !t.IsZero() {
+			entry.Updated = XMLDate(t)
+		}
+		if s := itemSummary(item); s != "" {
+			entry.Summary = &atomText{Type: "html", Body: s}
+		}
+		if c := item.String("content", ""); c != "" {
+			entry.Content = &atomText{Type: "html", Body: c}
+		}
+		doc.Entries = append(doc.Entries, entry)
+	}
+	return writeXML(w, doc)
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	ID          string `xml:",chardata"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate,omitempty"`
+	Description string  `xml:"description"`
+}
+
+func (f *feed) writeRSS(w io.Writer) error {
+	doc := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:         f.title,
+			Link:          f.link,
+			Description:   f.description,
+			LastBuildDate: f.updated().Format(time.RFC1123Z),
+		},
+	}
+	for _, item := range f.items {
+		desc := itemSummary(item)
+		if desc == "" {
+			desc = item.String("content", "")
+		}
+		ri := rssItem{
+			Title:       item.String("title", ""),
+			Link:        item.String("absurl", item.String("url", "")),
+			GUID:        rssGUID{ID: itemID(item)},
+			Description: desc,
+		}
+		if t := itemTime(item); !t.IsZero() {
+			ri.PubDate = t.Format(time.RFC1123Z)
+		}
+		doc.Channel.Items = append(doc.Channel.Items, ri)
+	}
+	return writeXML(w, doc)
+}
+
+func writeXML(w io.Writer, doc interface{}) error {
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", " ")
+	return enc.Encode(doc)
+}
+
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Description string         `json:"description,omitempty"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string `json:"id"`
+	URL           string `json:"url"`
+	Title         string `json:"title,omitempty"`
+	ContentHTML   string `json:"content_html,omitempty"`
+	Summary       string `json:"summary,omitempty"`
+	DatePublished string `json:"date_published,omitempty"`
+}
+
+func (f *feed) writeJSON(w io.Writer) error {
+	doc := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       f.title,
+		HomePageURL: f.link,
+		FeedURL:     f.self,
+		Description: f.description,
+		Items:       []jsonFeedItem{},
+	}
+	for _, item := range f.items {
+		ji := jsonFeedItem{
+			ID:          itemID(item),
+			URL:         item.String("absurl", item.String("url", "")),
+			Title:       item.String("title", ""),
+			ContentHTML: item.String("content", ""),
+			Summary:     itemSummary(item),
+		}
+		if t := itemTime(item); !t.IsZero() {
+			ji.DatePublished = t.Format(time.RFC3339)
+		}
+		doc.Items = append(doc.Items, ji)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
@@ -0,0 +1,210 @@
+package grout
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var registeredFuncs = make(map[string]interface{})
+
+// RegisterTemplateFunc makes fn available to page templates as name.
+// It takes precedence over the built-in function of the same name.
+func RegisterTemplateFunc(name string, fn interface{}) {
+	if _, ok := registeredFuncs[name]; ok {
+		log.Fatalf("Template func '%s' already exists!\n", name)
+	}
+	registeredFuncs[name] = fn
+}
+
+// builtinFuncs are the template functions that don't depend on the
+// site config.
+var builtinFuncs = map[string]interface{}{
+	"date":          formatDate,
+	"where":         where,
+	"sortBy":        sortBy,
+	"groupBy":       groupBy,
+	"first":         first,
+	"limit":         limit,
+	"slugify":       Slugify,
+	"truncatewords": func(n int, s string) string { return truncateWords(s, n) },
+	"jsonify":       jsonify,
+	"stripHTML":     stripHTML,
+}
+
+// templateFuncs returns the functions available to page and layout
+// templates.
+// data is the site's template data, for functions that depend on the
+// site config.
+func templateFuncs(data M) map[string]interface{} {
+	funcs := make(map[string]interface{}, len(builtinFuncs)+len(registeredFuncs)+8)
+	for name, fn := range builtinFuncs {
+		funcs[name] = fn
+	}
+	for name, fn := range urlFuncs(data) {
+		funcs[name] = fn
+	}
+	funcs["markdownify"] = func(s string) template.HTML {
+		return template.HTML(renderMarkdown([]byte(s), data.Map("markdown")))
+	}
+	for name, fn := range registeredFuncs {
+		funcs[name] = fn
+	}
+	return funcs
+}
+
+// funcDataKeys lists the template data keys a function reads, so
+// incremental builds can tell when its result could change.
+var funcDataKeys = map[string][]string{
+	"absURL":      {"url", "baseurl"},
+	"relURL":      {"url", "baseurl"},
+	"include":     {includeDeps},
+	"markdownify": {"markdown"},
+}
+
+// formatDate formats a time, or a date string as found in front matter
+// and collection metadata, with a Go time layout:
+//
+//	{{.date | date "Jan 2, 2006"}}
+func formatDate(layout string, v interface{}) (string, error) {
+	switch v := v.(type) {
+	case time.Time:
+		return v.Format(layout), nil
+	case string:
+		if t, ok := parseDate(v); ok {
+			return t.Format(layout), nil
+		}
+	}
+	return "", fmt.Errorf("date: can't read %v as a date", v)
+}
+
+// items converts a list from collection metadata or decoded data to a
+// []M, leaving out anything that isn't a map.
+func items(v interface{}) []M {
+	switch v := v.(type) {
+	case []M:
+		return v
+	case []interface{}:
+		list := make([]M, 0, len(v))
+		for _, item := range v {
+			if m, ok := sanitizeValue(item).(M); ok {
+				list = append(list, m)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// where returns the items whose key is value, or whose key is a list
+// of terms including value, such as posts with a tag:
+//
+//	{{range where .posts "tags" "go"}}
+func where(list interface{}, key string, value interface{}) []M {
+	var out []M
+	want := fmt.Sprint(value)
+	for _, item := range items(list) {
+		match := fmt.Sprint(item[key]) == want
+		for _, t := range termsOf(item[key]) {
+			match = match || t == want
+		}
+		if item[key] != nil && match {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// values returns the elements of v if it is a list, its terms if it is
+// a string, as read for taxonomies, or v on its own otherwise.
+func values(v interface{}) []interface{} {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		return v
+	case string:
+		var out []interface{}
+		for _, t := range termsOf(v) {
+			out = append(out, t)
+		}
+		return out
+	}
+	return []interface{}{v}
+}
+
+// sortBy returns the items sorted by key, in ascending order unless
+// "desc" follows the key. Numbers are compared as numbers and
+// everything else as text.
+func sortBy(list interface{}, key string, order ...string) []M {
+	sorted := append([]M(nil), items(list)...)
+	desc := len(order) > 0 && order[0] == "desc"
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if desc {
+			return lessValue(sorted[j][key], sorted[i][key])
+		}
+		return lessValue(sorted[i][key], sorted[j][key])
+	})
+	return sorted
+}
+
+func lessValue(a, b interface{}) bool {
+	x, xerr := strconv.ParseFloat(fmt.Sprint(a), 64)
+	y, yerr := strconv.ParseFloat(fmt.Sprint(b), 64)
+	if xerr == nil && yerr == nil {
+		return x < y
+	}
+	return fmt.Sprint(a) < fmt.Sprint(b)
+}
+
+// groupBy splits the items into groups by key, each with a name and
+// the items in it, in the order the names first appear. An item whose
+// key is a list, or a string of terms such as "go, web", is in the
+// group of each.
+func groupBy(list interface{}, key string) []M {
+	var groups []M
+	index := make(map[string]int)
+	for _, item := range items(list) {
+		for _, v := range values(item[key]) {
+			name := fmt.Sprint(v)
+			i, ok := index[name]
+			if !ok {
+				i = len(groups)
+				index[name] = i
+				groups = append(groups, M{"name": name, "items": []M{}})
+			}
+			groups[i]["items"] = append(groups[i]["items"].([]M), item)
+		}
+	}
+	return groups
+}
+
+// first returns the first of the items, or nil if there are none.
+func first(list interface{}) M {
+	l := items(list)
+	if len(l) == 0 {
+		return nil
+	}
+	return l[0]
+}
+
+// limit returns at most the first n items:
+//
+//	{{range .posts | limit 5}}
+func limit(n int, list interface{}) []M {
+	l := items(list)
+	if n >= 0 && len(l) > n {
+		l = l[:n]
+	}
+	return l
+}
+
+// jsonify encodes v as JSON.
+func jsonify(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
@@ -1,16 +1,35 @@
 package main
 
 import (
+	"flag"
 	"github.com/james4k/grout"
 	_ "github.com/james4k/grout/listing"
-	"runtime"
+	"log"
+	"strings"
+)
+
+var (
+	config = flag.String("config", "", "comma-separated config files, merged in order")
+	env    = flag.String("env", "", "environment block of the config to use")
+	drafts = flag.Bool("drafts", false, "include drafts and unpublished pages")
+	future = flag.Bool("future", false, "include posts dated in the future")
 )
 
 func main() {
-	runtime.GOMAXPROCS(runtime.NumCPU() * 2)
-	grout.Build("", "",
-		&grout.Options{
-			Verbose:  true,
-			HttpHost: ":8000",
-		})
+	flag.Parse()
+	opt := &grout.Options{
+		Verbose:   true,
+		HttpHost:  ":8000",
+		AutoBuild: true,
+		Drafts:    *drafts,
+		Future:    *future,
+		Env:       *env,
+	}
+	if *config != "" {
+		opt.Config = strings.Split(*config, ",")
+	}
+	err := grout.Run("", "", opt)
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
 }
@@ -2,17 +2,33 @@ package grout
 
 import (
 	"fmt"
-	"github.com/james4k/layouts"
 	"io/ioutil"
-	"launchpad.net/goyaml"
-	"log"
-	"net/http"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"time"
 )
 
-func Build(input, output string, opt *Options) {
+// Result describes a successful build.
+type Result struct {
+	Output      string
+	Content     int
+	Collections map[string]int
+	// Unchanged counts the pages carried over from the last build
+	// rather than rendered again.
+	Unchanged int
+
+	// Changed lists the input files that triggered the build. It is
+	// only set for rebuilds started by Watch.
+	Changed []string
+}
+
+// Build generates the site found in input and writes it to output. If
+// output is empty, it defaults to input/_site. Errors are one of
+// *ConfigError, *TemplateError, *ContentError or *CollectionError when
+// they can be attributed to a specific input.
+func Build(input, output string, opt *Options) (*Result, error) {
 	if input == "" {
 		input = "."
 	}
@@ -23,53 +39,91 @@ func Build(input, output string, opt *Options) {
 	b := &builder{Options: opt}
 	err := b.readConfig(input)
 	if err != nil {
-		log.Fatalf("%v\n", err)
+		return nil, err
 	}
 
-	content := b.walkFiles(input)
-	layouts.Clear()
-	err = layouts.Glob(filepath.Join(input, "_layouts", "*"))
+	tmplData := b.makeTemplateData()
+	tmplData["data"], err = readData(input)
 	if err != nil {
-		log.Fatalf("%v\n", err)
+		return nil, err
+	}
+	b.templates, err = readTemplates(input, templateFuncs(tmplData))
+	if err != nil {
+		return nil, err
 	}
 
-	tmplData := b.makeTemplateData()
+	content, err := b.walkFiles(input)
+	if err != nil {
+		return nil, err
+	}
 	err = b.readContent(content, tmplData)
 	if err != nil {
-		log.Fatalf("read error: %v\n", err)
+		return nil, err
+	}
+	if !b.Drafts {
+		content = dropUnpublished(content)
 	}
 
 	collections := b.makeCollections()
 	err = b.readCollections(input, collections, tmplData)
 	if err != nil {
-		log.Fatalf("read collections error: %v\n", err)
+		return nil, err
+	}
+	content = append(content, b.taxonomies(collections, tmplData)...)
+	content = append(content, b.archives(collections, tmplData)...)
+	feeds, err := b.feeds(content, tmplData)
+	if err != nil {
+		return nil, err
 	}
+	content = append(content, feeds...)
+	content = b.paginate(content, tmplData)
+	content = append(content, b.sitemap(content, collections)...)
 
 	tempdir, err := ioutil.TempDir(input, "_tmpsite_")
 	if err != nil {
-		log.Fatalf("failed to create temp dir: %v\n", err)
+		return nil, fmt.Errorf("failed to create temp dir: %v", err)
 	}
+	defer cleanTempDirs(input)
 
+	b.deps = newDepGraph(cacheDir(input, opt), b.templates, opt.Clean)
 	err = b.writeContent(tempdir, output, content, tmplData)
 	if err != nil {
-		log.Fatalf("write error: %v\n", err)
+		return nil, err
 	}
 
 	err = b.writeCollections(tempdir, output, collections, tmplData)
 	if err != nil {
-		log.Fatalf("write collections error: %v\n", err)
+		return nil, err
 	}
 
 	os.Rename(output, tempdir+"_old")
 	err = os.Rename(tempdir, output)
 	if err != nil {
-		log.Fatalf("%v\n", err)
+		return nil, err
+	}
+
+	err = b.deps.save()
+	if err != nil {
+		fmt.Printf("failed to save build cache: %v\n", err)
+	}
+
+	result := &Result{
+		Output:      output,
+		Content:     len(content),
+		Collections: make(map[string]int, len(collections)),
+		Unchanged:   b.deps.skipped,
+	}
+	for _, c := range collections {
+		result.Collections[c.name] = len(c.content)
 	}
+	return result, nil
+}
 
+func cleanTempDirs(input string) {
 	temppattern := filepath.Join(input, "_tmpsite_*")
 	tempmatches, err := filepath.Glob(temppattern)
 	if err != nil {
-		log.Fatalf("%v\n", err)
+		return
 	}
 
 	for _, tmp := range tempmatches {
@@ -80,43 +134,14 @@ func Build(input, output string, opt *Options) {
 		}
 		os.RemoveAll(tmp)
 	}
-
-	if opt.HttpHost != "" {
-		/*watcher, err := fsnotify.NewWatcher()
-		if err != nil {
-			halt("%v\n", err)
-		}*/
-		fmt.Printf("HTTP server listening on %s\n", opt.HttpHost)
-		http.Handle("/", http.FileServer(http.Dir(filepath.Join(output, ""))))
-		err = http.ListenAndServe(opt.HttpHost, nil)
-		if err != nil {
-			fmt.Println(err)
-		}
-	}
 }
 
 type builder struct {
 	*Options
-	cfg M
-}
-
-func (b *builder) readConfig(dir string) error {
-	m := defaultConfig
-	raw, err := ioutil.ReadFile(filepath.Join(dir, "_config.yml"))
-	if err != nil {
-		// if file doesn't exist, just return the default config
-		b.cfg = m
-		return nil
-	}
-
-	err = goyaml.Unmarshal(raw, m)
-	if err != nil {
-		return err
-	}
-
-	m.sanitize()
-	b.cfg = m
-	return nil
+	cfg       M
+	cfgPath   string
+	deps      *depGraph
+	templates *siteTemplates
 }
 
 func (b *builder) makeTemplateData() M {
@@ -127,10 +152,14 @@ func (b *builder) makeTemplateData() M {
 		}
 		m[k] = v
 	}
+	now := time.Now()
+	m["time"] = now
+	m["xmltime"] = XMLDate(now)
 	return m
 }
 
-func (b *builder) walkFiles(basepath string) []Content {
+func (b *builder) walkFiles(basepath string) ([]Content, error) {
+	var walkErr error
 	content := make([]Content, 0, 32)
 	filepath.Walk(basepath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -161,46 +190,61 @@ func (b *builder) walkFiles(basepath string) []Content {
 		}
 
 		ext := filepath.Ext(name)
-		switch ext {
-		case ".go":
-		case ".html", ".htm":
-			content = append(content, &HTMLDocument{
-				ContentInfo: ci,
-			})
-		case ".xml", ".css":
-			content = append(content, &TextDocument{
-				ContentInfo: ci,
-			})
-		default:
-			content = append(content, File{
-				ContentInfo: ci,
-			})
+		c, err := b.contentType(ext)(b.cfg, ci)
+		if err != nil {
+			if err == ErrIgnore {
+				return nil
+			}
+			walkErr = &ContentError{Op: "read", Path: relpath, Err: err}
+			return walkErr
 		}
+		applyDefaults(b.cfg, c, scope{path: relpath, typ: b.contentTypeName(ext)})
+		b.templates.give(c)
+		content = append(content, c)
 		return nil
 	})
-	return content
+	return content, walkErr
+}
+
+func (b *builder) workers() int {
+	if b.Workers > 0 {
+		return b.Workers
+	}
+	return runtime.NumCPU()
 }
 
 func (b *builder) readContent(content []Content, tmplData M) error {
-	var err error
-	for _, c := range content {
-		err = c.Read(tmplData)
+	return parallel(b.workers(), len(content), func(i int) error {
+		err := content[i].Read(tmplData)
 		if err != nil {
-			return err
+			return contentError("read", content[i], err)
 		}
-	}
-	return nil
+		return nil
+	})
 }
 
 func (b *builder) writeContent(dir, cachedir string, content []Content, data M) error {
+	// Directories go first so everything else has somewhere to go.
 	var err error
+	rest := make([]Content, 0, len(content))
 	for _, c := range content {
+		if !c.IsDir() {
+			rest = append(rest, c)
+			continue
+		}
 		err = c.Write(dir, cachedir, data)
 		if err != nil {
-			return err
+			return contentError("write", c, err)
 		}
 	}
-	return nil
+
+	return parallel(b.workers(), len(rest), func(i int) error {
+		err := b.deps.write(rest[i], nil, dir, cachedir, data)
+		if err != nil {
+			return contentError("write", rest[i], err)
+		}
+		return nil
+	})
 }
 
 func (b *builder) makeCollections() []collection {
@@ -215,7 +259,16 @@ func (b *builder) makeCollections() []collection {
 		if !ok {
 			continue
 		}
-		c := collection{name: name, config: props}
+		c := collection{
+			name:    name,
+			config:  props,
+			workers: b.workers(),
+			drafts:  b.Drafts,
+			future:  b.Future,
+
+			templates: b.templates,
+			typeName:  b.contentTypeName,
+		}
 		c.generate = generators[props.String("generator", "post")]
 		if c.generate == nil {
 			continue
@@ -231,7 +284,7 @@ func (b *builder) readCollections(dir string, collections []collection, tmplData
 		c := &collections[i]
 		err = c.Read(dir, b.cfg, tmplData)
 		if err != nil {
-			return err
+			return collectionError(c.name, err)
 		}
 	}
 	return nil
@@ -241,10 +294,37 @@ func (b *builder) writeCollections(dir, cachedir string, collections []collectio
 	var err error
 	for i := range collections {
 		c := &collections[i]
-		err = c.Write(dir, cachedir, data)
+		err = c.Write(dir, cachedir, data, b.deps)
 		if err != nil {
-			return err
+			return collectionError(c.name, err)
 		}
 	}
 	return nil
 }
+
+// dropUnpublished leaves out pages with "published: false" in their front
+// matter.
+func dropUnpublished(content []Content) []Content {
+	out := content[:0]
+	for _, c := range content {
+		if isPublished(c) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// contentError wraps err unless it already carries its own position.
+func contentError(op string, c Content, err error) error {
+	if _, ok := err.(*TemplateError); ok {
+		return err
+	}
+	return &ContentError{Op: op, Path: c.Path(), Err: err}
+}
+
+func collectionError(name string, err error) error {
+	if _, ok := err.(*CollectionError); ok {
+		return err
+	}
+	return &CollectionError{Collection: name, Err: err}
+}
@@ -1,9 +1,872 @@
 package grout
 
 import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestBlerg(t *testing.T) {
-	Build("test", "", &Options{Verbose: true})
+	_, err := Build("test", "", &Options{Verbose: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBuildTemplateError(t *testing.T) {
+	dir := newSite(t, map[string]string{
+		"index.html": "---\ntitle: x\n---\nok\n{{ end }}\n",
+	})
+
+	_, err := Build(dir, "", &Options{})
+	var tmplErr *TemplateError
+	if !errors.As(err, &tmplErr) {
+		t.Fatalf("expected *TemplateError, got %v", err)
+	}
+	if tmplErr.Line != 5 {
+		t.Errorf("expected line 5, got %d (%v)", tmplErr.Line, err)
+	}
+}
+
+func TestBadLayout(t *testing.T) {
+	dir := newSite(t, map[string]string{
+		"index.html": "---\nlayout: 3\n---\nhi",
+	})
+
+	_, err := Build(dir, "", &Options{})
+	if _, ok := err.(*ContentError); !ok {
+		t.Errorf("expected a ContentError for a layout that isn't a name, got %v", err)
+	}
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		err := os.MkdirAll(filepath.Dir(path), 0700)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(path, []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// newSite writes files to a new temp dir, which is removed when the
+// test is done.
+func newSite(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "grout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	writeFiles(t, dir, files)
+	return dir
+}
+
+// buildSite writes files to a new site and builds it with opt, failing
+// the test if the build does.
+func buildSite(t *testing.T, files map[string]string, opt *Options) string {
+	t.Helper()
+	dir := newSite(t, files)
+	if opt == nil {
+		opt = &Options{}
+	}
+	_, err := Build(dir, "", opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// siteFile returns the contents of the built file name in dir.
+func siteFile(t *testing.T, dir, name string) []byte {
+	t.Helper()
+	raw, err := ioutil.ReadFile(filepath.Join(dir, "_site", filepath.FromSlash(name)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+// readSite checks that each built file in dir has the contents expect
+// has for it.
+func readSite(t *testing.T, dir string, expect map[string]string) {
+	t.Helper()
+	for name, want := range expect {
+		got := siteFile(t, dir, name)
+		if string(got) != want {
+			t.Errorf("%s: expected %q, got %q", name, want, got)
+		}
+	}
+}
+
+func TestIncrementalBuild(t *testing.T) {
+	dir := buildSite(t, map[string]string{
+		"index.html":                    "{{range .posts}}{{.title}}{{end}}",
+		"about.html":                    "about {{.url}}{{include \"sig.html\"}}",
+		"_includes/sig.html":            "sig",
+		"dot.html":                      "{{with .}}dot{{end}}",
+		"_posts/2012-01-01-first.html":  "---\ntitle: First\n---\none",
+		"_posts/2012-01-02-second.html": "---\ntitle: Second\n---\ntwo",
+	}, nil)
+
+	result, err := Build(dir, "", &Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Unchanged != 5 {
+		t.Errorf("expected 5 unchanged pages, got %d", result.Unchanged)
+	}
+
+	writeFiles(t, dir, map[string]string{
+		"_posts/2012-01-02-second.html": "---\ntitle: Changed\n---\ntwo",
+	})
+	result, err = Build(dir, "", &Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// index.html, dot.html, the changed post and the post linking to
+	// it as its neighbour are rendered again
+	if result.Unchanged != 1 {
+		t.Errorf("expected 1 unchanged page, got %d", result.Unchanged)
+	}
+	readSite(t, dir, map[string]string{"index.html": "ChangedFirst"})
+
+	// only the page using an include renders again when it changes
+	writeFiles(t, dir, map[string]string{"_includes/sig.html": "new sig"})
+	result, err = Build(dir, "", &Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Unchanged != 4 {
+		t.Errorf("expected 4 unchanged pages, got %d", result.Unchanged)
+	}
+	readSite(t, dir, map[string]string{"about.html": "about new sig"})
+
+	// registering something new may change how any page renders
+	RegisterTemplateFunc(fmt.Sprintf("noop%d", len(registeredFuncs)),
+		func() string { return "" })
+	result, err = Build(dir, "", &Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Unchanged != 0 {
+		t.Errorf("expected every page to render again, got %d unchanged", result.Unchanged)
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	dir := buildSite(t, map[string]string{
+		"about.md":                   "---\ntitle: About\n---\n# Hello There\n\n\"{{.page.title}}\"\n\na | b\n---|---\n1 | 2\n",
+		"_posts/2012-01-01-first.md": "---\ntitle: First\n---\n*one*",
+	}, nil)
+
+	about := siteFile(t, dir, "about.html")
+	for _, want := range []string{`<h1 id="hello-there">`, "&ldquo;About&rdquo;", "<table>"} {
+		if !strings.Contains(string(about), want) {
+			t.Errorf("expected %q in %q", want, about)
+		}
+	}
+	post := siteFile(t, dir, "2012/01/01/first.html")
+	if !strings.Contains(string(post), "<em>one</em>") {
+		t.Errorf("post not converted: %q", post)
+	}
+}
+
+type upperFile struct {
+	File
+}
+
+func (f upperFile) Write(dir, cachedir string, data M) error {
+	raw, err := ioutil.ReadFile(f.FullPath())
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, f.Path()),
+		[]byte(strings.ToUpper(string(raw))), 0600)
+}
+
+func TestContentTypes(t *testing.T) {
+	if _, ok := contentTypes[".upper"]; !ok {
+		RegisterContentType(".upper", func(sitecfg M, info ContentInfo) (Content, error) {
+			return upperFile{File{info}}, nil
+		})
+	}
+	dir := buildSite(t, map[string]string{
+		"_config.yml": "name: site\ncontent_types:\n  .json: text\n  .psd: ignore\n",
+		"data.json":   `{"name": "{{.name}}"}`,
+		"shout.upper": "hey",
+		"big.psd":     "layers",
+	}, nil)
+
+	readSite(t, dir, map[string]string{
+		"data.json":   `{"name": "site"}`,
+		"shout.upper": "HEY",
+	})
+	_, err := os.Stat(filepath.Join(dir, "_site", "big.psd"))
+	if !os.IsNotExist(err) {
+		t.Errorf("expected big.psd to be ignored")
+	}
+}
+
+func TestPermalinks(t *testing.T) {
+	dir := buildSite(t, map[string]string{
+		"_config.yml":                   "collections:\n  posts:\n    permalink: /blog/:year/:slug/\n",
+		"index.html":                    "{{range .posts}}{{.url}} {{end}}",
+		"_posts/2012-01-01-first.html":  "one",
+		"_posts/2012-01-02-second.html": "---\npermalink: /:month/:day/:title.html\n---\ntwo",
+	}, nil)
+
+	readSite(t, dir, map[string]string{
+		"blog/2012/first/index.html": "one",
+		"01/02/second.html":          "two",
+		"index.html":                 "/01/02/second.html /blog/2012/first/ ",
+	})
+}
+
+func TestSiteURL(t *testing.T) {
+	dir := buildSite(t, map[string]string{
+		"_config.yml": "url: http://example.com\nbaseurl: /blog/\n" +
+			"collections:\n  posts:\n    dir: _posts\n",
+		"index.html":                   `{{range .posts}}{{.url}} {{.absurl}} {{.atomid}}{{end}} {{relURL "/css/a.css"}} {{absURL "feed.xml"}}`,
+		"_posts/2012-01-01-first.html": "one",
+		"_layouts/default.html":        `<link href="{{relURL "/css/a.css"}}">{{content}} {{slugify "Hi There"}}`,
+		"about.html":                   "---\nlayout: default\n---\n{{absURL \"about.html\"}}",
+	}, nil)
+
+	readSite(t, dir, map[string]string{
+		"index.html": "/blog/2012/01/01/first.html " +
+			"http://example.com/blog/2012/01/01/first.html " +
+			"http://example.com/blog/2012-01-01-first " +
+			"/blog/css/a.css http://example.com/blog/feed.xml",
+		"about.html": `<link href="/blog/css/a.css">http://example.com/blog/about.html hi-there`,
+	})
+}
+
+func TestPagination(t *testing.T) {
+	dir := buildSite(t, map[string]string{
+		"_config.yml": "collections:\n  posts:\n    dir: _posts\n",
+		"index.html": "---\npaginate: posts\nper_page: 2\n---\n" +
+			"{{.paginator.page}}/{{.paginator.total_pages}}:" +
+			"{{range .paginator.items}} {{.title}}{{end}}" +
+			" [{{.paginator.prev_url}}|{{.paginator.next_url}}]",
+		"_posts/2012-01-01-a.html": "---\ntitle: A\n---\n",
+		"_posts/2012-01-02-b.html": "---\ntitle: B\n---\n",
+		"_posts/2012-01-03-c.html": "---\ntitle: C\n---\n",
+	}, nil)
+
+	readSite(t, dir, map[string]string{
+		"index.html":        "1/2: C B [|/page/2/]",
+		"page/2/index.html": "2/2: A [/|]",
+	})
+}
+
+func TestTaxonomies(t *testing.T) {
+	dir := buildSite(t, map[string]string{
+		"_config.yml":       "taxonomies:\n  tags:\n    layout: tag\n  categories:\n",
+		"_layouts/tag.html": "{{.page.title}}:{{range .page.term.items}} {{.title}}{{end}}",
+		"index.html": "{{range $name, $t := .tags}}{{$name}}={{$t.count}} {{end}}" +
+			"{{.categories.News.url}}",
+		"_posts/2012-01-01-a.html": "---\ntitle: A\ntags: [go, web]\n---\n",
+		"_posts/2012-01-02-b.html": "---\ntitle: B\ntags: go\ncategories: News\n---\n",
+		"_posts/2012-01-03-c.html": "---\ntitle: C\ntags: Go, GO\n---\n",
+	}, nil)
+
+	readSite(t, dir, map[string]string{
+		"index.html":          "Go=3 web=1 /categories/news/",
+		"tags/go/index.html":  "Go: C B A",
+		"tags/web/index.html": "web: A",
+	})
+}
+
+func TestArchives(t *testing.T) {
+	dir := buildSite(t, map[string]string{
+		"_config.yml":              "archives:\n  layout: archive\n",
+		"_layouts/archive.html":    "{{.page.period}} {{.page.title}}:{{range .page.posts}} {{.title}}{{end}}",
+		"index.html":               "{{range .archives}}{{.year}}({{.count}}){{range .months}} {{.name}}={{.url}}{{end}};{{end}}",
+		"_posts/2011-12-01-a.html": "---\ntitle: A\n---\n",
+		"_posts/2012-03-01-b.html": "---\ntitle: B\n---\n",
+		"_posts/2012-03-05-c.html": "---\ntitle: C\n---\n",
+	}, nil)
+
+	readSite(t, dir, map[string]string{
+		"index.html":         "2012(2) March=/2012/03/;2011(1) December=/2011/12/;",
+		"2012/index.html":    "year 2012: C B",
+		"2012/03/index.html": "month March 2012: C B",
+		"2011/12/index.html": "month December 2011: A",
+	})
+}
+
+func TestFeeds(t *testing.T) {
+	dir := buildSite(t, map[string]string{
+		"_config.yml": "title: Trash & Co\nurl: http://example.com\n" +
+			"taxonomies:\n  tags:\n" +
+			"feeds:\n" +
+			"  atom:\n    limit: 1\n" +
+			"  rss:\n    format: rss\n    path: /rss.xml\n" +
+			"  tags:\n    taxonomy: tags\n    format: json\n    path: /tags/:term.json\n",
+		"_posts/2012-01-01-a.html": "---\ntitle: A <1>\ntags: go\n---\none",
+		"_posts/2012-01-02-b.html": "---\ntitle: B\n---\n<p>two</p>",
+	}, nil)
+
+	var atom atomFeed
+	raw := siteFile(t, dir, "atom.xml")
+	err := xml.Unmarshal(raw, &atom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if atom.Title != "Trash & Co" || len(atom.Entries) != 1 ||
+		atom.Author == nil || atom.Author.Name != "Trash & Co" ||
+		atom.Entries[0].Content.Body != "<p>two</p>" ||
+		atom.Updated != "2012-01-02T00:00:00+00:00" {
+		t.Errorf("unexpected atom feed: %s", raw)
+	}
+
+	var rss rssFeed
+	raw = siteFile(t, dir, "rss.xml")
+	err = xml.Unmarshal(raw, &rss)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rss.Channel.Items) != 2 || rss.Channel.Items[1].Title != "A <1>" ||
+		rss.Channel.Items[1].Link != "http://example.com/2012/01/01/a.html" {
+		t.Errorf("unexpected rss feed: %s", raw)
+	}
+
+	var tag jsonFeed
+	raw = siteFile(t, dir, "tags/go.json")
+	err = json.Unmarshal(raw, &tag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tag.Items) != 1 || tag.FeedURL != "http://example.com/tags/go.json" {
+		t.Errorf("unexpected tag feed: %s", raw)
+	}
+
+	// a hand-written feed wins over a generated one
+	dir = buildSite(t, map[string]string{
+		"_config.yml":              "feeds:\n  atom:\n",
+		"atom.xml":                 "mine",
+		"_posts/2012-01-01-a.html": "a",
+	}, nil)
+	readSite(t, dir, map[string]string{"atom.xml": "mine"})
+}
+
+func TestSitemap(t *testing.T) {
+	dir := buildSite(t, map[string]string{
+		"_config.yml":              "url: http://example.com/blog\nsitemap:\n  limit: 2\n",
+		"index.html":               "home",
+		"about.html":               "---\nlastmod: 2013-05-01\n---\nabout",
+		"secret.html":              "---\nsitemap: false\n---\nshh",
+		"style.css":                "body {}",
+		"_posts/2012-01-01-a.html": "a",
+	}, nil)
+
+	var index sitemapIndexSet
+	raw := siteFile(t, dir, "sitemap.xml")
+	err := xml.Unmarshal(raw, &index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Sitemaps) != 2 ||
+		index.Sitemaps[1].Loc != "http://example.com/blog/sitemap-2.xml" {
+		t.Fatalf("unexpected sitemap index: %s", raw)
+	}
+
+	var urls []sitemapURL
+	for _, name := range []string{"sitemap-1.xml", "sitemap-2.xml"} {
+		var set sitemapURLSet
+		err = xml.Unmarshal(siteFile(t, dir, name), &set)
+		if err != nil {
+			t.Fatal(err)
+		}
+		urls = append(urls, set.URLs...)
+	}
+	expected := []string{
+		"http://example.com/blog/",
+		"http://example.com/blog/2012/01/01/a.html",
+		"http://example.com/blog/about.html",
+	}
+	if len(urls) != len(expected) {
+		t.Fatalf("expected %d urls, got %+v", len(expected), urls)
+	}
+	for i, u := range urls {
+		if u.Loc != expected[i] {
+			t.Errorf("url %d: expected %s, got %s", i, expected[i], u.Loc)
+		}
+		if u.LastMod == "" {
+			t.Errorf("url %s has no lastmod", u.Loc)
+		}
+	}
+	if urls[2].LastMod != "2013-05-01T00:00:00+00:00" {
+		t.Errorf("unexpected lastmod from front matter: %s", urls[2].LastMod)
+	}
+
+	robots := siteFile(t, dir, "robots.txt")
+	if !strings.Contains(string(robots), "Sitemap: http://example.com/blog/sitemap.xml") {
+		t.Errorf("robots.txt doesn't point at the sitemap: %s", robots)
+	}
+}
+
+func TestDrafts(t *testing.T) {
+	dir := buildSite(t, map[string]string{
+		"index.html":                   "{{range .posts}}{{.title}};{{end}}",
+		"hidden.html":                  "---\npublished: false\n---\nhidden",
+		"_posts/2012-01-01-old.html":   "---\ntitle: old\n---\n",
+		"_posts/2012-01-02-wip.html":   "---\ntitle: wip\npublished: false\n---\n",
+		"_posts/2999-01-01-later.html": "---\ntitle: later\n---\n",
+		"_drafts/idea.html":            "---\ntitle: idea\n---\n",
+	}, nil)
+
+	readSite(t, dir, map[string]string{"index.html": "old;"})
+	if _, err := os.Stat(filepath.Join(dir, "_site", "hidden.html")); err == nil {
+		t.Error("unpublished page was written")
+	}
+
+	_, err := Build(dir, "", &Options{Drafts: true, Future: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := siteFile(t, dir, "index.html")
+	for _, title := range []string{"old;", "wip;", "later;", "idea;"} {
+		if !strings.Contains(string(index), title) {
+			t.Errorf("expected %q in preview, got %q", title, index)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "_site", "hidden.html")); err != nil {
+		t.Error("unpublished page wasn't written for preview")
+	}
+}
+
+func TestExcerpts(t *testing.T) {
+	dir := buildSite(t, map[string]string{
+		"_config.yml":                 "summary_words: 3\n",
+		"index.html":                  "{{range .posts}}{{.excerpt}}|{{.summary}}\n{{end}}",
+		"_posts/2012-01-01-para.html": "<p>First &amp; foremost.</p>\n<p>Second.</p>",
+		"_posts/2012-01-02-more.html": "Intro <em>text</em>\n<!--more-->\nRest of it.",
+		"_posts/2012-01-03-set.html":  "---\nexcerpt: Given & *written*\nsummary: Also given\n---\n<p>Body</p>",
+	}, nil)
+
+	readSite(t, dir, map[string]string{
+		"index.html": "<p>Given &amp; <em>written</em></p>|Also given\n" +
+			"Intro <em>text</em>|Intro text Rest…\n" +
+			"<p>First &amp; foremost.</p>|First &amp; foremost.…\n",
+	})
+}
+
+func TestRelated(t *testing.T) {
+	dir := buildSite(t, map[string]string{
+		"_config.yml": "collections:\n  posts:\n    generator: post\n    related_limit: 2\n",
+		"_posts/2012-01-01-a.html": "---\ntitle: a\ntags: trash cans\n---\n" +
+			"{{range .page.related}}{{.title}};{{end}}",
+		"_posts/2012-01-02-b.html": "---\ntitle: b\ntags: trash\n---\nnothing alike",
+		"_posts/2012-01-03-c.html": "---\ntitle: c\ntags: trash cans\n---\nworms",
+		"_posts/2012-01-04-d.html": "---\ntitle: d\n---\nsome worms live in cans",
+		"_posts/2012-01-05-e.html": "---\ntitle: e\n---\nunrelated",
+		"index.html": "{{range .posts}}{{if eq .title \"d\"}}" +
+			"{{range .related}}{{.title}};{{end}}{{end}}{{end}}",
+	}, nil)
+
+	readSite(t, dir, map[string]string{
+		// posts sharing the most tags come first
+		"2012/01/01/a.html": "c;b;",
+		// untagged posts are related by their text
+		"index.html": "c;",
+	})
+
+	// collections without related_limit aren't ranked at all
+	dir = buildSite(t, map[string]string{
+		"_posts/2012-01-01-a.html": "---\ntags: trash\n---\n[{{range .page.related}}{{.title}}{{end}}]",
+		"_posts/2012-01-02-b.html": "---\ntags: trash\n---\n",
+	}, nil)
+	readSite(t, dir, map[string]string{"2012/01/01/a.html": "[]"})
+}
+
+func TestNeighbours(t *testing.T) {
+	nav := "{{with .page.previous}}prev:{{.title}} {{.url}} {{.date}}{{end}}" +
+		"{{with .page.next}} next:{{.title}}{{end}}" +
+		" [{{.page.first.title}}-{{.page.last.title}}]" +
+		"{{with .page.neighbours.go}} go:{{.previous.title}}/{{.next.title}}{{end}}"
+	dir := buildSite(t, map[string]string{
+		"_posts/2012-01-01-a.html": "---\ntitle: a\ntags: go\n---\n" + nav,
+		"_posts/2012-01-02-b.html": "---\ntitle: b\n---\n" + nav,
+		"_posts/2012-01-03-c.html": "---\ntitle: c\ntags: go\n---\n" + nav,
+	}, nil)
+
+	readSite(t, dir, map[string]string{
+		"2012/01/01/a.html": "prev:b /2012/01/02/b.html 2012-01-02 [c-a] go:c/",
+		"2012/01/02/b.html": "prev:c /2012/01/03/c.html 2012-01-03 next:a [c-a]",
+		"2012/01/03/c.html": " next:b [c-a] go:/a",
+	})
+}
+
+func TestFrontMatterDefaults(t *testing.T) {
+	dir := buildSite(t, map[string]string{
+		"_config.yml": "defaults:\n" +
+			"  - scope:\n      collection: posts\n    values:\n      kind: post\n      author: oscar\n" +
+			"  - scope:\n      path: docs\n    values:\n      kind: doc\n" +
+			"  - scope:\n      path: \"docs/*.md\"\n      type: markdown\n    values:\n      kind: markdown doc\n" +
+			"  - scope:\n      collection: posts\n      type: markdown\n    values:\n      author: slimey\n" +
+			"content_types:\n  .htm: markdown\n",
+		"index.html":                  "{{.page.kind}}",
+		"docs/a.html":                 "{{.page.kind}}",
+		"docs/b.md":                   "{{.page.kind}}",
+		"_posts/2012-01-01-a.html":    "{{.page.kind}} by {{.page.author}}",
+		"_posts/2012-01-02-mine.html": "---\nauthor: me\n---\n{{.page.kind}} by {{.page.author}}",
+		"_posts/2012-01-03-md.htm":    "{{.page.kind}} by {{.page.author}}",
+	}, nil)
+
+	readSite(t, dir, map[string]string{
+		"index.html":           "",
+		"docs/a.html":          "doc",
+		"docs/b.html":          "<p>markdown doc</p>\n",
+		"2012/01/01/a.html":    "post by oscar",
+		"2012/01/02/mine.html": "post by me",
+		"2012/01/03/md.html":   "post by slimey",
+	})
+}
+
+func TestDataFiles(t *testing.T) {
+	dir := buildSite(t, map[string]string{
+		"_data/nav.yml":          "- title: Home\n  url: /\n- title: About\n  url: /about.html\n",
+		"_data/team/oscar.json":  `{"name": "Oscar", "likes": ["trash"]}`,
+		"_data/team/slimey.toml": "name = \"Slimey\"\n[pet]\nkind = \"worm\"\n",
+		"_data/talks.csv":        "year,title\n2012,Rock Cellar\n2011,AAP National\n",
+		"_data/notes.txt":        "not data",
+		"index.html": "{{range .data.nav}}{{.title}}={{.url}};{{end}}" +
+			"{{.data.team.oscar.name}} likes {{index .data.team.oscar.likes 0}};" +
+			"{{.data.team.slimey.name}} the {{.data.team.slimey.pet.kind}};" +
+			"{{range .data.talks}}{{.year}} {{.title}};{{end}}" +
+			"{{if .data.notes}}notes{{end}}",
+	}, nil)
+
+	readSite(t, dir, map[string]string{
+		"index.html": "Home=/;About=/about.html;Oscar likes trash;Slimey the worm;" +
+			"2012 Rock Cellar;2011 AAP National;",
+	})
+
+	writeFiles(t, dir, map[string]string{"_data/nav.json": "[]"})
+	_, err := Build(dir, "", &Options{})
+	if _, ok := err.(*ConfigError); !ok {
+		t.Errorf("expected a ConfigError for data defined twice, got %v", err)
+	}
+}
+
+func TestIncludes(t *testing.T) {
+	dir := buildSite(t, map[string]string{
+		"_config.yml":           "title: Trash & Co\nincludes: mine\n",
+		"_includes/footer.html": "<footer>{{.title}} {{include \"share.html\" . \"url\" \"/a?b&c\"}}</footer>",
+		"_includes/share.html":  `<a href="{{.include.url}}">share</a>`,
+		"_includes/sig.txt":     "-- {{.title}}",
+		"_layouts/default.html": `<body>{{content}}{{include "sig.txt" .}}</body>`,
+		"index.html":            "---\ntitle: Home\n---\n{{include \"footer.html\" .}}",
+		"notes.xml":             `{{include "sig.txt" .}}`,
+		"about.html":            "---\nlayout: default\n---\n{{.includes}} ",
+	}, nil)
+
+	readSite(t, dir, map[string]string{
+		"index.html": `<footer>Trash &amp; Co <a href="/a?b&amp;c">share</a></footer>`,
+		"notes.xml":  "-- Trash & Co",
+		"about.html": "<body>mine -- Trash &amp; Co</body>",
+	})
+
+	writeFiles(t, dir, map[string]string{
+		"_includes/share.html": `{{index .include 1}}`,
+	})
+	_, err := Build(dir, "", &Options{})
+	tmplErr, ok := err.(*TemplateError)
+	if !ok {
+		t.Fatalf("expected a TemplateError, got %v", err)
+	}
+	incErr, ok := tmplErr.Err.(*IncludeError)
+	if !ok || strings.Join(incErr.Chain, ",") != "footer.html,share.html" {
+		t.Errorf("expected the include chain, got %v", err)
+	}
+	if tmplErr.Line != 4 {
+		t.Errorf("expected line 4 of the page, got %d", tmplErr.Line)
+	}
+
+	writeFiles(t, dir, map[string]string{
+		"_includes/share.html": `{{include "footer.html" .}}`,
+	})
+	_, err = Build(dir, "", &Options{})
+	if !errors.As(err, &incErr) || !errors.Is(err, errIncludeCycle) ||
+		strings.Join(incErr.Chain, ",") != "footer.html,share.html,footer.html" {
+		t.Errorf("expected an include cycle, got %v", err)
+	}
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	if _, ok := registeredFuncs["shout"]; !ok {
+		RegisterTemplateFunc("shout", strings.ToUpper)
+	}
+	dir := buildSite(t, map[string]string{
+		"_posts/2012-01-01-a.html": "---\ntitle: A\ntags: go trash\nrank: 10\n---\n<p>a</p>",
+		"_posts/2012-03-02-b.html": "---\ntitle: B\ntags: trash\nrank: 9\n---\n<p>b</p>",
+		"_posts/2012-03-03-c.html": "---\ntitle: C\nrank: 100\n---\n<p>c</p>",
+		"index.html": `{{range .posts | limit 2}}{{.date | date "Jan 2"}};{{end}}|` +
+			`{{range where .posts "tags" "trash"}}{{.title}}{{end}}|` +
+			`{{range sortBy .posts "rank" "desc"}}{{.title}}{{end}}|` +
+			`{{range groupBy .posts "tags"}}{{.name}}={{len .items}};{{end}}|` +
+			`{{(first .posts).title}}|` +
+			`{{slugify "Oscar's Trash Can"}}|` +
+			`{{"one two three four" | truncatewords 2}}|` +
+			`{{jsonify .page}}|` +
+			`{{markdownify "*hi*"}}|` +
+			`{{stripHTML "<p>a &amp; b</p>"}}|` +
+			`{{shout "hey"}}`,
+	}, nil)
+
+	readSite(t, dir, map[string]string{
+		"index.html": "Mar 3;Mar 2;|BA|CAB|trash=2;go=1;|C|oscar-s-trash-can|" +
+			"one two…|{}|<p><em>hi</em></p>\n|a &amp; b|HEY",
+	})
+}
+
+func TestFrontMatterFormats(t *testing.T) {
+	for _, config := range []string{
+		"_config.yml:title: Trash\nurl: http://example.com\n",
+		"_config.toml:title = \"Trash\"\nurl = \"http://example.com\"\n",
+		`_config.json:{"title": "Trash", "url": "http://example.com"}`,
+	} {
+		parts := strings.SplitN(config, ":", 2)
+		dir := buildSite(t, map[string]string{
+			parts[0]:              parts[1],
+			"yaml.html":           "---\nname: yaml\ntags: [a, b]\n---\n{{.title}} {{.page.name}} {{index .page.tags 1}}",
+			"toml.html":           "+++\nname = \"toml\"\ntags = [\"a\", \"b\"]\n[nested]\nx = 1\n+++\n{{.title}} {{.page.name}} {{index .page.tags 1}} {{.page.nested.x}}",
+			"json.html":           "{\n  \"name\": \"json\",\n  \"tags\": [\"a\", \"b\"]\n}\n{{.title}} {{.page.name}} {{index .page.tags 1}}",
+			"plain.html":          "{{.title}} {{absURL \"/\"}}",
+			"empty.html":          "+++\n+++\n{{.title}}",
+			"_layouts/toml.html":  "+++\nlayout = \"outer\"\n+++\n<main>{{content}}</main>",
+			"_layouts/outer.html": "<body>{{content}}</body>",
+			"wrapped.html":        "+++\nlayout = \"toml\"\n+++\nhi",
+		}, nil)
+
+		readSite(t, dir, map[string]string{
+			"yaml.html":    "Trash yaml b",
+			"toml.html":    "Trash toml b 1",
+			"json.html":    "Trash json b",
+			"plain.html":   "Trash http://example.com/",
+			"empty.html":   "Trash",
+			"wrapped.html": "<body><main>hi</main></body>",
+		})
+	}
+}
+
+func TestLayeredConfig(t *testing.T) {
+	os.Setenv("GROUT_TITLE", "yes")
+	os.Setenv("GROUT_DESCRIPTION", "Trash: a love story")
+	os.Setenv("GROUT_MARKDOWN__SMARTYPANTS", "false")
+	defer os.Unsetenv("GROUT_TITLE")
+	defer os.Unsetenv("GROUT_DESCRIPTION")
+	defer os.Unsetenv("GROUT_MARKDOWN__SMARTYPANTS")
+	dir := buildSite(t, map[string]string{
+		"base.yml": "title: Trash\nurl: http://localhost\nmarkdown:\n  tables: false\n  footnotes: false\n" +
+			"environments:\n  production:\n    url: http://example.com\n",
+		"prod.toml":  "[markdown]\ntables = true\n",
+		"site.conf":  "title: Trash\n",
+		"index.html": "{{.title}} {{.description}} {{.url}} {{.markdown.tables}} {{.markdown.footnotes}} {{.markdown.smartypants}}",
+	}, &Options{
+		Config: []string{"base.yml", "prod.toml"},
+		Env:    "production",
+	})
+
+	readSite(t, dir, map[string]string{
+		"index.html": "yes Trash: a love story http://example.com true false false",
+	})
+	if _, ok := defaultConfig["title"]; ok {
+		t.Error("building changed the default config")
+	}
+
+	_, err := Build(dir, "", &Options{Config: []string{"missing.yml"}})
+	if _, ok := err.(*ConfigError); !ok {
+		t.Errorf("expected a ConfigError for a missing config file, got %v", err)
+	}
+	_, err = Build(dir, "", &Options{Config: []string{"site.conf"}})
+	if _, ok := err.(*ConfigError); !ok {
+		t.Errorf("expected a ConfigError for an unknown config format, got %v", err)
+	}
+}
+
+func TestConfigCheck(t *testing.T) {
+	if _, ok := generators["thumbs"]; !ok {
+		RegisterGenerator("thumbs", GeneratePost)
+		RegisterGeneratorConfig("thumbs", map[string]string{"thumb_width": "int"})
+	}
+	tests := []struct {
+		file, config string
+		line         int
+		msg          string
+	}{
+		{"_config.yml", "titel: Trash\n", 0, ""},
+		{"_config.yml", "titel: Trash\ncollections:\n  gallery:\n    generator: thumbs\n    thumb_widht: 100\n",
+			5, `"collections/gallery/thumb_widht" unknown key, did you mean "thumb_width"?`},
+		{"_config.yml", "sitemap:\n  limit: lots\n",
+			2, `"sitemap/limit" should be an int, not a string`},
+		{"_config.yml", "collections:\n  posts:\n    generator: psot\n",
+			3, `"collections/posts/generator" no generator "psot" is registered, did you mean "post"?`},
+		{"_config.yml", "collections:\n  posts: yes\n",
+			2, `"collections/posts" should be a map, not a bool`},
+		{"_config.toml", "title = \"Trash\"\n[markdown]\ntabels = true\n",
+			3, `"markdown/tabels" unknown key, did you mean "tables"?`},
+		{"_config.json", "{\n  \"feeds\": {\n    \"atom\": {\"limit\": \"ten\"}\n  }\n}\n",
+			3, `"feeds/atom/limit" should be an int, not a string`},
+	}
+	for _, test := range tests {
+		dir := newSite(t, map[string]string{test.file: test.config})
+
+		_, err := Build(dir, "", &Options{})
+		if test.msg == "" {
+			if err != nil {
+				t.Errorf("%q: unexpected error %v", test.config, err)
+			}
+			continue
+		}
+		cfgErr, ok := err.(*ConfigError)
+		if !ok {
+			t.Errorf("%q: expected a ConfigError, got %v", test.config, err)
+			continue
+		}
+		if cfgErr.Line != test.line || cfgErr.Err.Error() != test.msg {
+			t.Errorf("%q: expected line %d: %s, got %v", test.config, test.line, test.msg, err)
+		}
+	}
+}
+
+func TestLayeredConfigCheck(t *testing.T) {
+	if _, ok := generators["thumbs"]; !ok {
+		RegisterGenerator("thumbs", GeneratePost)
+		RegisterGeneratorConfig("thumbs", map[string]string{"thumb_width": "int"})
+	}
+	base := "collections:\n  gallery:\n    generator: thumbs\n" +
+		"environments:\n  production:\n    collections:\n      gallery:\n        thumb_width: 300\n"
+	dir := newSite(t, map[string]string{
+		"base.yml": base,
+		"prod.yml": "collections:\n  gallery:\n    thumb_width: 200\n",
+		"typo.yml": "title: Trash\ncollections:\n  gallery:\n    thumb_widht: 200\n",
+	})
+
+	_, err := Build(dir, "", &Options{Config: []string{"base.yml", "prod.yml"}, Env: "production"})
+	if err != nil {
+		t.Errorf("unexpected error for a generator's key set over another file: %v", err)
+	}
+
+	os.Setenv("GROUT_COLLECTIONS__GALLERY__THUMB_WIDTH", "100")
+	_, err = Build(dir, "", &Options{Config: []string{"base.yml"}})
+	os.Unsetenv("GROUT_COLLECTIONS__GALLERY__THUMB_WIDTH")
+	if err != nil {
+		t.Errorf("unexpected error for a generator's key set by the environment: %v", err)
+	}
+
+	_, err = Build(dir, "", &Options{Config: []string{"base.yml", "typo.yml"}})
+	cfgErr, ok := err.(*ConfigError)
+	if !ok {
+		t.Fatalf("expected a ConfigError, got %v", err)
+	}
+	if cfgErr.File != filepath.Join(dir, "typo.yml") || cfgErr.Line != 4 {
+		t.Errorf("expected the error in line 4 of typo.yml, got %v", err)
+	}
+
+	os.Setenv("GROUT_COLLECTIONS__GALLERY__THUMB_WIDTH", "wide")
+	_, err = Build(dir, "", &Options{Config: []string{"base.yml"}})
+	os.Unsetenv("GROUT_COLLECTIONS__GALLERY__THUMB_WIDTH")
+	if cfgErr, ok := err.(*ConfigError); !ok || cfgErr.File != "environment" {
+		t.Errorf("expected a ConfigError from the environment, got %v", err)
+	}
+}
+
+func TestLiveReloadInjection(t *testing.T) {
+	dir := newSite(t, map[string]string{
+		"index.html": "<html><body>hi</body></html>",
+	})
+
+	srv := httptest.NewServer(newServer(dir, true))
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), reloadPath) ||
+		!strings.HasSuffix(string(body), "</body></html>") {
+		t.Errorf("reload script not injected: %s", body)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir := buildSite(t, map[string]string{"index.html": "one"}, nil)
+
+	builds := make(chan error, 8)
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- watch(dir, "", &Options{}, 50*time.Millisecond, func(r *Result, err error) {
+			builds <- err
+		}, stop)
+	}()
+	defer func() {
+		close(stop)
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}()
+	// give the watcher time to start watching
+	time.Sleep(100 * time.Millisecond)
+
+	rebuilt := func() error {
+		t.Helper()
+		select {
+		case err := <-builds:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("no rebuild after a change")
+		}
+		return nil
+	}
+	quiet := func(what string) {
+		t.Helper()
+		select {
+		case <-builds:
+			t.Errorf("rebuilt after %s", what)
+		case <-time.After(300 * time.Millisecond):
+		}
+	}
+
+	writeFiles(t, dir, map[string]string{"index.html": "two", "about.html": "about"})
+	writeFiles(t, dir, map[string]string{"index.html": "three"})
+	if err := rebuilt(); err != nil {
+		t.Fatal(err)
+	}
+	quiet("a burst of changes was already built")
+	readSite(t, dir, map[string]string{"index.html": "three", "about.html": "about"})
+
+	writeFiles(t, dir, map[string]string{
+		"_site/stray.html":      "stray",
+		"_cache/stray":          "stray",
+		"_tmpsite_1/stray.html": "stray",
+		".index.html.swp":       "swap",
+		"index.html~":           "backup",
+	})
+	quiet("changes to output, caches and scratch files")
+
+	writeFiles(t, dir, map[string]string{"index.html": "{{ end }}"})
+	if err := rebuilt(); err == nil {
+		t.Fatal("expected the rebuild to fail")
+	}
+	readSite(t, dir, map[string]string{"index.html": "three"})
 }
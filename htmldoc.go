@@ -1,29 +1,52 @@
 package grout
 
 import (
-	"github.com/james4k/fmatter"
-	"github.com/james4k/layouts"
+	"bytes"
+	"fmt"
 	"html/template"
 	"os"
 	"path/filepath"
+	"text/template/parse"
 )
 
 type HTMLDocument struct {
 	ContentInfo
 	FrontMatter M
 	Template    *template.Template
+
+	// Defaults are front matter values the document has unless its
+	// own front matter sets them.
+	Defaults M
+
+	// Markdown converts the content to HTML before it is parsed as a
+	// template. Files with a .md or .markdown extension always are.
+	Markdown bool
+
+	lineOffset int
+	templates  *siteTemplates
 }
 
 func (d *HTMLDocument) Read(data M) error {
 	var err error
 	d.FrontMatter = make(M, 8)
-	content, err := fmatter.ReadFile(d.FullPath(), d.FrontMatter)
+	content, offset, err := readFrontMatter(d.FullPath(), d.FrontMatter)
 	if err != nil {
 		return err
 	}
+	d.lineOffset = offset
+	mergeDefaults(d.FrontMatter, d.Defaults)
+	if layout := d.FrontMatter["layout"]; layout != nil {
+		if _, ok := layout.(string); !ok {
+			return fmt.Errorf("layout should be the name of a layout, not %v", layout)
+		}
+	}
+	if d.markdown() {
+		content = renderMarkdown(content, data.Map("markdown"))
+	}
 
-	d.Template, err = template.New(d.Path()).Parse(string(content))
-	return err
+	t := template.New(d.Path()).Funcs(template.FuncMap(templateFuncs(data)))
+	d.Template, err = d.templates.addHTMLIncludes(t).Parse(string(content))
+	return d.WrapTemplateError(err)
 }
 
 func (d *HTMLDocument) Write(dir, cachedir string, data M) error {
@@ -33,12 +56,53 @@ func (d *HTMLDocument) Write(dir, cachedir string, data M) error {
 	}
 	defer newf.Close()
 
-	data["page"] = d.FrontMatter
-	if layout, ok := d.FrontMatter["layout"]; ok && layout != "nil" {
-		err = layouts.Execute(newf, layout.(string), d.Template, data)
-	} else {
-		err = d.Template.Execute(newf, data)
+	data = data.With("page", d.FrontMatter)
+	layout := d.layoutName()
+	if layout == "" {
+		return d.WrapTemplateError(d.Template.Execute(newf, data))
+	}
+	var buf bytes.Buffer
+	err = d.Template.Execute(&buf, data)
+	if err != nil {
+		return d.WrapTemplateError(err)
+	}
+	return d.templates.execute(newf, layout, template.HTML(buf.String()), data)
+}
+
+// WrapTemplateError wraps an error from parsing or executing
+// d.Template as a *TemplateError with a line number relative to the
+// start of the file.
+func (d *HTMLDocument) WrapTemplateError(err error) error {
+	return templateError(d.FullPath(), d.Path(), d.lineOffset, err)
+}
+
+func (d *HTMLDocument) markdown() bool {
+	return d.Markdown || isMarkdown(d.FullPath())
+}
+
+func (d *HTMLDocument) templateTrees() []*parse.Tree {
+	var trees []*parse.Tree
+	for _, t := range d.Template.Templates() {
+		trees = append(trees, t.Tree)
+	}
+	return trees
+}
+
+func (d *HTMLDocument) extraDataKeys() []string {
+	var keys []string
+	if d.markdown() {
+		keys = append(keys, "markdown")
+	}
+	if d.Defaults != nil {
+		keys = append(keys, "defaults")
+	}
+	return keys
+}
+
+func (d *HTMLDocument) layoutName() string {
+	layout, _ := d.FrontMatter["layout"].(string)
+	if layout == "nil" {
+		return ""
 	}
-	delete(data, "page")
-	return err
+	return layout
 }
@@ -0,0 +1,184 @@
+package grout
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+// readIncludes reads the templates in the _includes dir of the site,
+// keyed by file name. Each one is parsed here only to report mistakes
+// against the include itself rather than every page using it.
+func readIncludes(dir string) (map[string]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "_includes", "*"))
+	if err != nil {
+		return nil, err
+	}
+	includes := make(map[string]string, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() || info.Name()[0] == '.' {
+			continue
+		}
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		_, err = template.New(info.Name()).
+			Funcs(template.FuncMap(templateFuncs(nil))).
+			Funcs(template.FuncMap{"include": noInclude}).
+			Parse(string(raw))
+		if err != nil {
+			return nil, NewTemplateError(path, err)
+		}
+		includes[info.Name()] = string(raw)
+	}
+	return includes, nil
+}
+
+func noInclude(name string, args ...interface{}) (string, error) {
+	return "", nil
+}
+
+// errIncludeCycle is the error of an include reached again through
+// the includes it makes.
+var errIncludeCycle = errors.New("includes itself")
+
+// parseIncludes parses the includes into a set of text templates and a
+// set of HTML templates. Neither set is executed itself: each include
+// runs in a copy of its set that knows the chain of includes leading
+// to it, so that an include reached again is an error rather than
+// endless recursion.
+func (t *siteTemplates) parseIncludes() error {
+	t.textIncludes = template.New("").
+		Funcs(template.FuncMap(t.funcs)).
+		Funcs(template.FuncMap{"include": t.textInclude(nil)})
+	t.htmlIncludes = htmltemplate.New("").
+		Funcs(htmltemplate.FuncMap(t.funcs)).
+		Funcs(htmltemplate.FuncMap{"include": t.htmlInclude(nil)})
+	for name, src := range t.includes {
+		_, err := t.textIncludes.New(name).Parse(src)
+		if err == nil {
+			_, err = t.htmlIncludes.New(name).Parse(src)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// executor is a template set that includes can be executed from.
+type executor interface {
+	ExecuteTemplate(w io.Writer, name string, data interface{}) error
+}
+
+// include executes the last include of chain from t. With no args, it
+// gets no data. The first arg is the data it gets, usually the
+// caller's own dot, and any args after that are name and value pairs
+// set as .include in that data:
+//
+//	{{include "share.html" . "url" .page.url}}
+func include(t executor, chain []string, args ...interface{}) (string, error) {
+	var data interface{}
+	if len(args) > 0 {
+		data = args[0]
+	}
+	if len(args) > 1 {
+		params := make(M, len(args)/2)
+		for i := 1; i+1 < len(args); i += 2 {
+			if k, ok := args[i].(string); ok {
+				params[k] = args[i+1]
+			}
+		}
+		if m, ok := data.(M); ok {
+			data = m.With("include", params)
+		} else {
+			data = M{"include": params}
+		}
+	}
+
+	var buf bytes.Buffer
+	err := t.ExecuteTemplate(&buf, chain[len(chain)-1], data)
+	if err != nil {
+		// an include further in already knows the whole chain
+		var inner *IncludeError
+		if errors.As(err, &inner) {
+			return "", inner
+		}
+		return "", &IncludeError{Chain: chain, Err: err}
+	}
+	return buf.String(), nil
+}
+
+// includeChain returns chain with name on the end, or an error if name
+// is in it already.
+func includeChain(chain []string, name string) ([]string, error) {
+	next := append(append([]string(nil), chain...), name)
+	for _, n := range chain {
+		if n == name {
+			return nil, &IncludeError{Chain: next, Err: errIncludeCycle}
+		}
+	}
+	return next, nil
+}
+
+// textInclude returns the include function for text templates that
+// were reached through the includes in chain.
+func (t *siteTemplates) textInclude(chain []string) func(string, ...interface{}) (string, error) {
+	return func(name string, args ...interface{}) (string, error) {
+		chain, err := includeChain(chain, name)
+		if err != nil {
+			return "", err
+		}
+		if t == nil {
+			return "", &IncludeError{Chain: chain, Err: fmt.Errorf("no include called %q", name)}
+		}
+		set, err := t.textIncludes.Clone()
+		if err != nil {
+			return "", err
+		}
+		set.Funcs(template.FuncMap{"include": t.textInclude(chain)})
+		return include(set, chain, args...)
+	}
+}
+
+// htmlInclude is textInclude for HTML templates. Included output has
+// already been escaped by its own template, so it isn't again.
+func (t *siteTemplates) htmlInclude(chain []string) func(string, ...interface{}) (htmltemplate.HTML, error) {
+	return func(name string, args ...interface{}) (htmltemplate.HTML, error) {
+		chain, err := includeChain(chain, name)
+		if err != nil {
+			return "", err
+		}
+		if t == nil {
+			return "", &IncludeError{Chain: chain, Err: fmt.Errorf("no include called %q", name)}
+		}
+		set, err := t.htmlIncludes.Clone()
+		if err != nil {
+			return "", err
+		}
+		set.Funcs(htmltemplate.FuncMap{"include": t.htmlInclude(chain)})
+		s, err := include(set, chain, args...)
+		return htmltemplate.HTML(s), err
+	}
+}
+
+// addIncludes gives tmpl the include function.
+func (t *siteTemplates) addIncludes(tmpl *template.Template) *template.Template {
+	return tmpl.Funcs(template.FuncMap{"include": t.textInclude(nil)})
+}
+
+// addHTMLIncludes is addIncludes for HTML templates.
+func (t *siteTemplates) addHTMLIncludes(tmpl *htmltemplate.Template) *htmltemplate.Template {
+	return tmpl.Funcs(htmltemplate.FuncMap{"include": t.htmlInclude(nil)})
+}
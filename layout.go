@@ -0,0 +1,168 @@
+package grout
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	texttemplate "text/template"
+)
+
+// siteTemplates are the templates every page of a site shares: the
+// layouts in its _layouts dir and the includes in its _includes dir.
+type siteTemplates struct {
+	includes map[string]string
+	layouts  map[string]*layout
+	// funcs are the template functions layouts and includes get
+	funcs template.FuncMap
+
+	// the includes, parsed by parseIncludes
+	textIncludes *texttemplate.Template
+	htmlIncludes *template.Template
+}
+
+// layout is a template that wraps the pages naming it in their front
+// matter, putting each where it calls content. It may name a layout of
+// its own in its front matter to be wrapped in turn:
+//
+//	---
+//	layout: default
+//	---
+//	<article>{{content}}</article>
+type layout struct {
+	name       string
+	path       string
+	parent     string
+	template   *template.Template
+	lineOffset int
+}
+
+// usesTemplates is content rendered with the site's templates, such as
+// an HTMLDocument or anything embedding one.
+type usesTemplates interface {
+	setTemplates(*siteTemplates)
+}
+
+func (d *HTMLDocument) setTemplates(t *siteTemplates) {
+	d.templates = t
+}
+
+func (d *TextDocument) setTemplates(t *siteTemplates) {
+	d.templates = t
+}
+
+func (p *layoutPage) setTemplates(t *siteTemplates) {
+	p.templates = t
+}
+
+// give hands t to c if c is rendered with the site's templates.
+func (t *siteTemplates) give(c Content) {
+	if u, ok := c.(usesTemplates); ok {
+		u.setTemplates(t)
+	}
+}
+
+// readTemplates reads the layouts and includes of the site in dir,
+// giving the layouts funcs.
+func readTemplates(dir string, funcs map[string]interface{}) (*siteTemplates, error) {
+	includes, err := readIncludes(dir)
+	if err != nil {
+		return nil, err
+	}
+	t := &siteTemplates{
+		includes: includes,
+		layouts:  make(map[string]*layout),
+		funcs:    template.FuncMap(funcs),
+	}
+	err = t.parseIncludes()
+	if err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "_layouts", "*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() || info.Name()[0] == '.' {
+			continue
+		}
+		l, err := t.readLayout(path)
+		if err != nil {
+			return nil, err
+		}
+		t.layouts[l.name] = l
+	}
+	return t, nil
+}
+
+func (t *siteTemplates) readLayout(path string) (*layout, error) {
+	fm := make(M, 4)
+	content, offset, err := readFrontMatter(path, fm)
+	if err != nil {
+		return nil, &TemplateError{File: path, Err: err}
+	}
+	l := &layout{
+		name:       replaceExt(filepath.Base(path), ""),
+		path:       path,
+		parent:     fm.String("layout", ""),
+		lineOffset: offset,
+	}
+	tmpl := template.New(l.name).Funcs(t.funcs).Funcs(template.FuncMap{
+		"content": func() template.HTML { return "" },
+	})
+	tmpl, err = t.addHTMLIncludes(tmpl).Parse(string(content))
+	if err != nil {
+		return nil, templateError(path, l.name, offset, err)
+	}
+	l.template = tmpl
+	return l, nil
+}
+
+// execute writes content wrapped in the layout called name, and then
+// in each layout that one names, in turn.
+func (t *siteTemplates) execute(w io.Writer, name string, content template.HTML, data M) error {
+	seen := make(map[string]bool)
+	var buf bytes.Buffer
+	for name != "" {
+		var l *layout
+		if t != nil {
+			l = t.layouts[name]
+		}
+		if l == nil {
+			return fmt.Errorf("no layout called %q", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("layout %q is wrapped in itself", name)
+		}
+		seen[name] = true
+
+		buf.Reset()
+		err := l.execute(&buf, content, data)
+		if err != nil {
+			return err
+		}
+		content = template.HTML(buf.String())
+		name = l.parent
+	}
+	_, err := io.WriteString(w, string(content))
+	return err
+}
+
+// execute renders the layout around content. Every page gets its own
+// copy of the layout's template, as the content differs.
+func (l *layout) execute(w io.Writer, content template.HTML, data M) error {
+	tmpl, err := l.template.Clone()
+	if err != nil {
+		return err
+	}
+	tmpl.Funcs(template.FuncMap{
+		"content": func() template.HTML { return content },
+	})
+	return templateError(l.path, l.name, l.lineOffset, tmpl.Execute(w, data))
+}
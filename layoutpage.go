@@ -0,0 +1,47 @@
+package grout
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// layoutPage is a generated page with no source file, such as a
+// taxonomy term or date archive. Its layout renders it from the page
+// object alone.
+type layoutPage struct {
+	path      string
+	layout    string
+	page      M
+	templates *siteTemplates
+}
+
+func (p *layoutPage) IsDir() bool {
+	return false
+}
+
+func (p *layoutPage) FullPath() string {
+	return ""
+}
+
+func (p *layoutPage) Path() string {
+	return p.path
+}
+
+func (p *layoutPage) Read(data M) error {
+	return nil
+}
+
+func (p *layoutPage) Write(dir, cachedir string, data M) error {
+	path := filepath.Join(dir, p.path)
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return p.templates.execute(f, p.layout, "", data.With("page", p.page))
+}
@@ -10,6 +10,7 @@ import (
 	_ "image/png"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"strconv"
@@ -19,14 +20,21 @@ type Listing struct {
 	*HTMLDocument
 	id          int
 	url         string
+	link        string
 	img         string
 	thumb       string
+	imgbase     string
+	site        SiteURL
 	thumbWidth  int
 	thumbHeight int
 	content     string
+	vars        map[string]string
 	metadata    M
 }
 
+// defaultPermalink is used when the collection has no permalink.
+const defaultPermalink = "/:path/:id/:slug.html"
+
 var listingNameRE = regexp.MustCompile(`^([0-9]{1,10})-([0-9A-z\-]+)$`)
 
 func (l *Listing) Read(data M) error {
@@ -34,12 +42,13 @@ func (l *Listing) Read(data M) error {
 	if err != nil {
 		return err
 	}
+	if pattern := l.FrontMatter.String("permalink", ""); pattern != "" {
+		l.setPermalink(pattern)
+	}
 	buf := bytes.NewBuffer(make([]byte, 0, 256))
-	data["page"] = l.FrontMatter
-	err = l.Template.Execute(buf, data)
-	delete(data, "page")
+	err = l.Template.Execute(buf, data.With("page", l.FrontMatter))
 	if err != nil {
-		return err
+		return l.WrapTemplateError(err)
 	}
 	l.content = string(buf.Bytes())
 	l.metadata = make(M, 8)
@@ -48,18 +57,37 @@ func (l *Listing) Read(data M) error {
 	}
 	l.metadata["id"] = l.id
 	l.metadata["url"] = l.url
+	l.metadata["absurl"] = l.site.Abs(l.link)
 	l.metadata["img"] = l.img
 	l.metadata["thumb"] = l.thumb
 	l.metadata["content"] = l.content
 	return nil
 }
 
+// setPermalink moves the listing, and its images along with it, to
+// where pattern says.
+func (l *Listing) setPermalink(pattern string) {
+	file, link := Permalink(pattern, l.vars)
+	l.SetPath(file)
+	l.link = link
+	l.url = l.site.Rel(link)
+	l.imgbase = path.Join(path.Dir(file), l.vars["slug"])
+	l.img = l.site.Rel(l.imgbase + ".jpg")
+	l.thumb = l.site.Rel(l.imgbase + "_thumb.jpg")
+}
+
+// PostRead adds the paths of the neighbouring listings to the links
+// to them, which the collection has already made. Listings used to
+// have the paths themselves as prev and next, so prev is kept as
+// another name for previous, and .prev.path and .next.path are what
+// .prev and .next were.
 func (l *Listing) PostRead(data M, collection []Content, i int) error {
-	if i > 0 {
-		l.metadata["prev"] = collection[i-1].Path()
+	if prev := l.metadata.Map("previous"); prev != nil && i > 0 {
+		prev["path"] = collection[i-1].Path()
+		l.metadata["prev"] = prev
 	}
-	if i+1 < len(collection) {
-		l.metadata["next"] = collection[i+1].Path()
+	if next := l.metadata.Map("next"); next != nil && i+1 < len(collection) {
+		next["path"] = collection[i+1].Path()
 	}
 	return nil
 }
@@ -102,24 +130,52 @@ func (l *Listing) Less(other Collectable) bool {
 	return l.id > otherListing.id
 }
 
-func (l *Listing) writeImages(dir, cachedir string, data M) error {
+// Inputs returns the source image, which writeImages reads.
+func (l *Listing) Inputs() []string {
+	path := l.imagePath()
+	if path == "" {
+		return nil
+	}
+	return []string{path}
+}
+
+// Outputs returns the resized images written by writeImages.
+func (l *Listing) Outputs() []string {
+	return []string{l.imgbase + ".jpg", l.imgbase + "_thumb.jpg"}
+}
+
+// imagePath returns the path of the listing's source image, or "" if
+// it has none.
+func (l *Listing) imagePath() string {
 	path := l.FullPath()
-	ext := filepath.Ext(path)
-	path = path[:len(path)-len(ext)]
-	file, err := os.Open(path + ".png")
-	if err != nil {
-		file, err = os.Open(path + ".jpg")
+	path = path[:len(path)-len(filepath.Ext(path))]
+	for _, ext := range []string{".png", ".jpg"} {
+		_, err := os.Stat(path + ext)
+		if err == nil {
+			return path + ext
+		}
+	}
+	return ""
+}
+
+func (l *Listing) writeImages(dir, cachedir string, data M) error {
+	path := l.imagePath()
+	if path == "" {
+		return fmt.Errorf("no .png or .jpg image for %s", l.FullPath())
 	}
+	file, err := os.Open(path)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	outpath := l.Path()
-	ext = filepath.Ext(outpath)
-	outpath = outpath[:len(outpath)-len(ext)]
+	outpath := filepath.FromSlash(l.imgbase)
 	cachepath := filepath.Join(cachedir, outpath)
 	outpath = filepath.Join(dir, outpath)
+	err = os.MkdirAll(filepath.Dir(outpath), 0700)
+	if err != nil {
+		return err
+	}
 	cacheinfo, err := os.Stat(cachepath + ".jpg")
 	if err == nil {
 		info, err := file.Stat()
@@ -229,7 +285,7 @@ func GenerateListing(sitecfg, cfg M, info ContentInfo) (Content, error) {
 	path := info.Path()
 	ext := filepath.Ext(path)
 	switch ext {
-	case ".html", ".htm":
+	case ".html", ".htm", ".md", ".markdown":
 		withoutExt := path[:len(path)-len(ext)]
 		matches := listingNameRE.FindStringSubmatch(withoutExt)
 		if len(matches) < 3 {
@@ -241,43 +297,23 @@ func GenerateListing(sitecfg, cfg M, info ContentInfo) (Content, error) {
 			return nil, err
 		}
 
-		// TODO: support custom permalinks, and date format for
-		// metadata
-		info.SetPath(fmt.Sprintf("/%s/%d/%s.html",
-			cfg.String("path", "listing"), id, matches[2]))
-
-		//baseurl := sitecfg.String("url", "")
-		baseurl := ""
-		url, err := BuildURL(baseurl, info.Path())
-		if err != nil {
-			return nil, err
-		}
-
-		img, err := BuildURL(baseurl,
-			fmt.Sprintf("/%s/%d/%s.jpg",
-				cfg.String("path", "listing"),
-				id, matches[2]))
-		if err != nil {
-			return nil, err
-		}
-
-		thumb, err := BuildURL(baseurl,
-			fmt.Sprintf("/%s/%d/%s_thumb.jpg",
-				cfg.String("path", "listing"),
-				id, matches[2]))
-		if err != nil {
-			return nil, err
-		}
-
-		return &Listing{
+		// TODO: support date format for metadata
+		site := NewSiteURL(sitecfg)
+		l := &Listing{
 			HTMLDocument: &HTMLDocument{ContentInfo: info},
 			id:           id,
-			url:          url,
-			img:          img,
-			thumb:        thumb,
+			site:         site,
 			thumbWidth:   cfg.Int("thumb_width", 0),
 			thumbHeight:  cfg.Int("thumb_height", 0),
-		}, nil
+			vars: map[string]string{
+				"path":  cfg.String("path", "listing"),
+				"id":    strconv.Itoa(id),
+				"slug":  matches[2],
+				"title": matches[2],
+			},
+		}
+		l.setPermalink(cfg.String("permalink", defaultPermalink))
+		return l, nil
 	default:
 		return nil, ErrIgnore
 	}
@@ -286,4 +322,10 @@ func GenerateListing(sitecfg, cfg M, info ContentInfo) (Content, error) {
 
 func init() {
 	RegisterGenerator("listing", GenerateListing)
+	RegisterGeneratorConfig("listing", map[string]string{
+		"path":         "string",
+		"permalink":    "string",
+		"thumb_width":  "int",
+		"thumb_height": "int",
+	})
 }
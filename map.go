@@ -2,6 +2,7 @@ package grout
 
 import (
 	"fmt"
+	"html/template"
 	"strings"
 )
 
@@ -15,8 +16,20 @@ func (m M) Map(path string) M {
 	return val
 }
 
+// String returns the string at path, or def if there isn't one. HTML
+// such as a post's excerpt counts as a string.
 func (m M) String(path string, def string) string {
-	val, ok := m.get(path).(string)
+	switch val := m.get(path).(type) {
+	case string:
+		return val
+	case template.HTML:
+		return string(val)
+	}
+	return def
+}
+
+func (m M) Bool(path string, def bool) bool {
+	val, ok := m.get(path).(bool)
 	if !ok {
 		return def
 	}
@@ -31,6 +44,17 @@ func (m M) Int(path string, def int) int {
 	return val
 }
 
+// With returns a shallow copy of m with key set to val, leaving m
+// itself untouched so it can be shared between concurrent renders.
+func (m M) With(key string, val interface{}) M {
+	n := make(M, len(m)+1)
+	for k, v := range m {
+		n[k] = v
+	}
+	n[key] = val
+	return n
+}
+
 func (m M) get(path string) interface{} {
 	parts := strings.Split(path, "/")
 	for i, p := range parts {
@@ -53,17 +77,45 @@ func (m M) get(path string) interface{} {
 
 func (m M) sanitize() {
 	for k, v := range m {
-		vmap, ok := v.(map[interface{}]interface{})
-		if !ok {
-			continue
-		}
+		m[k] = sanitizeValue(v)
+	}
+}
 
-		newv := make(M, len(vmap))
-		for vk, vv := range vmap {
+// sanitizeValue turns the maps goyaml, JSON and TOML decode into M,
+// including those inside lists.
+func sanitizeValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		newv := make(M, len(v))
+		for vk, vv := range v {
 			s := fmt.Sprintf("%v", vk)
 			newv[s] = vv
 		}
 		newv.sanitize()
-		m[k] = newv
+		return newv
+	case map[string]interface{}:
+		m := M(v)
+		m.sanitize()
+		return m
+	case []map[string]interface{}:
+		// how TOML decodes arrays of tables
+		list := make([]interface{}, len(v))
+		for i := range v {
+			list[i] = sanitizeValue(v[i])
+		}
+		return list
+	case []interface{}:
+		for i := range v {
+			v[i] = sanitizeValue(v[i])
+		}
+	case int64:
+		// TOML's integers, which M.Int wouldn't take
+		return int(v)
+	case float64:
+		// JSON's numbers, likewise
+		if v == float64(int(v)) {
+			return int(v)
+		}
 	}
+	return v
 }
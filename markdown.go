@@ -0,0 +1,73 @@
+package grout
+
+import (
+	"fmt"
+	"github.com/russross/blackfriday"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+// markdownExts are the extensions of files converted from Markdown,
+// even when the generator making the HTMLDocument didn't ask for it.
+var markdownExts = map[string]bool{
+	".md":       true,
+	".markdown": true,
+}
+
+func isMarkdown(path string) bool {
+	return markdownExts[filepath.Ext(path)]
+}
+
+var (
+	actionRE      = regexp.MustCompile(`(?s)\{\{.*?\}\}`)
+	placeholderRE = regexp.MustCompile(`GROUTACTION([0-9]+)END`)
+)
+
+// renderMarkdown converts Markdown source to HTML. Template actions are
+// left untouched so the result can still be parsed as a template. cfg
+// is the "markdown" section of the site config, and may be nil.
+func renderMarkdown(src []byte, cfg M) []byte {
+	var actions [][]byte
+	src = actionRE.ReplaceAllFunc(src, func(action []byte) []byte {
+		actions = append(actions, action)
+		return []byte(fmt.Sprintf("GROUTACTION%dEND", len(actions)-1))
+	})
+
+	flags := blackfriday.HTML_USE_XHTML
+	if cfg.Bool("smartypants", true) {
+		flags |= blackfriday.HTML_USE_SMARTYPANTS |
+			blackfriday.HTML_SMARTYPANTS_FRACTIONS |
+			blackfriday.HTML_SMARTYPANTS_DASHES |
+			blackfriday.HTML_SMARTYPANTS_LATEX_DASHES
+	}
+	if cfg.Bool("footnotes", true) {
+		flags |= blackfriday.HTML_FOOTNOTE_RETURN_LINKS
+	}
+
+	extensions := blackfriday.EXTENSION_NO_INTRA_EMPHASIS |
+		blackfriday.EXTENSION_FENCED_CODE |
+		blackfriday.EXTENSION_AUTOLINK |
+		blackfriday.EXTENSION_STRIKETHROUGH |
+		blackfriday.EXTENSION_SPACE_HEADERS
+	if cfg.Bool("tables", true) {
+		extensions |= blackfriday.EXTENSION_TABLES
+	}
+	if cfg.Bool("footnotes", true) {
+		extensions |= blackfriday.EXTENSION_FOOTNOTES
+	}
+	if cfg.Bool("header_ids", true) {
+		extensions |= blackfriday.EXTENSION_HEADER_IDS |
+			blackfriday.EXTENSION_AUTO_HEADER_IDS
+	}
+
+	renderer := blackfriday.HtmlRenderer(flags, "", "")
+	out := blackfriday.Markdown(src, renderer, extensions)
+	return placeholderRE.ReplaceAllFunc(out, func(p []byte) []byte {
+		i, err := strconv.Atoi(string(placeholderRE.FindSubmatch(p)[1]))
+		if err != nil || i >= len(actions) {
+			return p
+		}
+		return actions[i]
+	})
+}
@@ -4,4 +4,31 @@ type Options struct {
 	Verbose   bool
 	HttpHost  string
 	AutoBuild bool
+
+	// Workers is how many pages are read and written at once. It
+	// defaults to the number of CPUs.
+	Workers int
+
+	// CacheDir holds state kept between builds. It defaults to
+	// _cache in the input dir.
+	CacheDir string
+	// Clean ignores what was cached by earlier builds, rendering
+	// every page again.
+	Clean bool
+
+	// Drafts includes posts from _drafts and anything with
+	// "published: false" in its front matter.
+	Drafts bool
+	// Future includes collection items dated in the future, which
+	// are otherwise left out until the site is built on that date.
+	Future bool
+
+	// Config lists the config files to read, relative to the site,
+	// such as "_config.yml" then "_prod.yml". Later files are merged
+	// over earlier ones. It defaults to the first of _config.yml,
+	// _config.yaml, _config.toml and _config.json found.
+	Config []string
+	// Env picks the block of the config's environments section that
+	// is merged over the rest. It defaults to $GROUT_ENV.
+	Env string
 }
@@ -0,0 +1,128 @@
+package grout
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+)
+
+// defaultPaginatePath is where the pages after the first go, relative
+// to the paginated document's directory.
+const defaultPaginatePath = "page/:num/"
+
+// paginatedPage is one page of an HTMLDocument whose front matter asks
+// for a collection to be split across pages, such as:
+//
+//	paginate: posts
+//	per_page: 10
+//
+// Every page, including the first, gets a paginator object with the
+// items for that page along with links to its neighbours.
+type paginatedPage struct {
+	*HTMLDocument
+	collection string
+	paginator  M
+}
+
+func (p *paginatedPage) Write(dir, cachedir string, data M) error {
+	err := os.MkdirAll(filepath.Dir(filepath.Join(dir, p.Path())), 0700)
+	if err != nil {
+		return err
+	}
+	return p.HTMLDocument.Write(dir, cachedir, data.With("paginator", p.paginator))
+}
+
+func (p *paginatedPage) extraDataKeys() []string {
+	return append(p.HTMLDocument.extraDataKeys(), p.collection)
+}
+
+// paginate replaces every document asking for pagination with one
+// paginatedPage per page. It must run after collections are read.
+func (b *builder) paginate(content []Content, data M) []Content {
+	site := NewSiteURL(b.cfg)
+	out := make([]Content, 0, len(content))
+	for _, c := range content {
+		d, ok := c.(*HTMLDocument)
+		if !ok || d.FrontMatter.String("paginate", "") == "" {
+			out = append(out, c)
+			continue
+		}
+
+		name := d.FrontMatter.String("paginate", "")
+		items, _ := data[name].([]M)
+		perPage := d.FrontMatter.Int("per_page", 10)
+		if perPage < 1 {
+			perPage = 1
+		}
+		pages := (len(items) + perPage - 1) / perPage
+		if pages == 0 {
+			pages = 1
+		}
+
+		pattern := d.FrontMatter.String("paginate_path", defaultPaginatePath)
+		dir := path.Dir(d.Path())
+		files := make([]string, pages+1)
+		links := make([]string, pages+1)
+		files[1] = d.Path()
+		links[1] = site.Rel(d.Path())
+		if path.Base(d.Path()) == "index.html" {
+			links[1] = site.Rel(dirLink(dir))
+		}
+		for n := 2; n <= pages; n++ {
+			file, link := Permalink(pattern, map[string]string{
+				"num": strconv.Itoa(n),
+			})
+			files[n] = path.Join(dir, file)
+			links[n] = site.Rel(path.Join("/", dir, link))
+			if link[len(link)-1] == '/' {
+				links[n] = site.Rel(dirLink(path.Join(dir, link)))
+			}
+		}
+
+		for n := 1; n <= pages; n++ {
+			lo := (n - 1) * perPage
+			hi := lo + perPage
+			if lo > len(items) {
+				lo = len(items)
+			}
+			if hi > len(items) {
+				hi = len(items)
+			}
+			paginator := M{
+				"items":       items[lo:hi],
+				"page":        n,
+				"per_page":    perPage,
+				"total_pages": pages,
+				"total_items": len(items),
+				"url":         links[n],
+			}
+			if n > 1 {
+				paginator["prev_page"] = n - 1
+				paginator["prev_url"] = links[n-1]
+			}
+			if n < pages {
+				paginator["next_page"] = n + 1
+				paginator["next_url"] = links[n+1]
+			}
+
+			page := *d
+			page.SetPath(files[n])
+			out = append(out, &paginatedPage{
+				HTMLDocument: &page,
+				collection:   name,
+				paginator:    paginator,
+			})
+		}
+	}
+	return out
+}
+
+// dirLink returns the link to a directory, with a trailing slash.
+func dirLink(dir string) string {
+	link := path.Join("/", dir)
+	if link != "/" {
+		link += "/"
+	}
+	return link
+}
@@ -0,0 +1,31 @@
+package grout
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+var permalinkVarRE = regexp.MustCompile(`:([a-z_]+)`)
+
+// Permalink expands the :name placeholders in pattern, such as
+// "/:year/:month/:slug/", with values from vars. Unknown placeholders
+// are left as they are. It returns the path to write to, relative to
+// the output dir, and the link to it. A pattern ending in a slash makes
+// a pretty URL, written as index.html inside that directory.
+func Permalink(pattern string, vars map[string]string) (file, link string) {
+	link = permalinkVarRE.ReplaceAllStringFunc(pattern, func(v string) string {
+		if val, ok := vars[v[1:]]; ok {
+			return val
+		}
+		return v
+	})
+	if !strings.HasPrefix(link, "/") {
+		link = "/" + link
+	}
+	file = strings.TrimPrefix(link, "/")
+	if strings.HasSuffix(link, "/") {
+		file = path.Join(file, "index.html")
+	}
+	return file, link
+}
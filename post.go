@@ -3,9 +3,11 @@ package grout
 import (
 	"bytes"
 	"fmt"
+	"html/template"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
 	"time"
 )
 
@@ -15,10 +17,22 @@ type Post struct {
 	date     string
 	xmldate  string
 	url      string
+	link     string
 	atomid   string
+	site     SiteURL
+	vars     map[string]string
+	draft    bool
 	metadata M
+
+	// excerptSep and summaryWords shape the excerpt and summary
+	// metadata.
+	excerptSep   string
+	summaryWords int
 }
 
+// defaultPostPermalink is used when the collection has no permalink.
+const defaultPostPermalink = "/:year/:month/:day/:slug.html"
+
 var postNameRE = regexp.MustCompile(`^([0-9]{4})-([0-9]{2})-([0-9]{2})-([0-9A-z\-]+)$`)
 
 func (p *Post) Read(data M) error {
@@ -26,26 +40,61 @@ func (p *Post) Read(data M) error {
 	if err != nil {
 		return err
 	}
+	if pattern := p.FrontMatter.String("permalink", ""); pattern != "" {
+		p.setPermalink(pattern)
+	}
 
 	buf := bytes.NewBuffer(make([]byte, 0, 512))
-	data["page"] = p.FrontMatter
-	err = p.Template.Execute(buf, data)
-	delete(data, "page")
+	err = p.Template.Execute(buf, data.With("page", p.FrontMatter))
 	if err != nil {
-		return err
+		return p.WrapTemplateError(err)
+	}
+	p.metadata = make(M, len(p.FrontMatter)+8)
+	for k, v := range p.FrontMatter {
+		p.metadata[k] = v
+	}
+	p.metadata["title"] = p.FrontMatter["title"]
+	p.metadata["date"] = p.date
+	p.metadata["xmldate"] = p.xmldate
+	p.metadata["url"] = p.url
+	p.metadata["absurl"] = p.site.Abs(p.link)
+	p.metadata["atomid"] = p.atomid
+	p.metadata["content"] = string(buf.Bytes())
+	// excerpts are HTML however they are made, so templates show
+	// them alike
+	if v, ok := p.FrontMatter["excerpt"]; ok {
+		md := renderMarkdown([]byte(fmt.Sprint(v)), data.Map("markdown"))
+		p.metadata["excerpt"] = template.HTML(strings.TrimSpace(string(md)))
+	} else {
+		p.metadata["excerpt"] = template.HTML(excerpt(string(buf.Bytes()), p.excerptSep))
 	}
-	p.metadata = M{
-		"title":   p.FrontMatter["title"],
-		"date":    p.date,
-		"xmldate": p.xmldate,
-		"url":     p.url,
-		"atomid":  p.atomid,
-		"content": string(buf.Bytes()),
+	if _, ok := p.FrontMatter["summary"]; !ok {
+		p.metadata["summary"] = truncateWords(stripHTML(string(buf.Bytes())),
+			p.summaryWords)
+	}
+	if p.draft {
+		p.metadata["draft"] = true
 	}
 	return nil
 }
 
+func (p *Post) setPermalink(pattern string) {
+	path, link := Permalink(pattern, p.vars)
+	p.SetPath(path)
+	p.link = link
+	p.url = p.site.Rel(link)
+}
+
+// collectionKeys are the metadata a collection adds to its items, which
+// posts pass on to their own page.
+var collectionKeys = []string{"related", "previous", "next", "first", "last", "neighbours"}
+
 func (p *Post) PostRead(data M, collection []Content, i int) error {
+	for _, k := range collectionKeys {
+		if v, ok := p.metadata[k]; ok {
+			p.FrontMatter[k] = v
+		}
+	}
 	return nil
 }
 
@@ -81,29 +130,25 @@ func GeneratePost(sitecfg, cfg M, info ContentInfo) (Content, error) {
 	path := info.Path()
 	ext := filepath.Ext(path)
 	switch ext {
-	case ".html", ".htm":
+	case ".html", ".htm", ".md", ".markdown":
 		withoutExt := path[:len(path)-len(ext)]
 		matches := postNameRE.FindStringSubmatch(withoutExt)
+		draft := cfg.Bool("draft", false)
 		if len(matches) < 5 {
-			return nil, fmt.Errorf("bad post name: %s", path)
+			if !draft {
+				return nil, fmt.Errorf("bad post name: %s", path)
+			}
+			// drafts needn't be dated yet, so they go by when
+			// they were last saved
+			t := info.ModTime()
+			matches = []string{withoutExt, t.Format("2006"),
+				t.Format("01"), t.Format("02"), Slugify(withoutExt)}
 		}
 
-		// TODO: support custom permalinks, and date format for
-		// metadata
-		info.SetPath(fmt.Sprintf("%s/%s/%s/%s.html",
-			matches[1], matches[2], matches[3], matches[4]))
-
-		//baseurl := sitecfg.String("url", "")
-		baseurl := ""
-		url, err := BuildURL(baseurl, info.Path())
-		if err != nil {
-			return nil, err
-		}
-		atomid, err := BuildURL(baseurl, fmt.Sprintf("%s-%s-%s-%s",
+		// TODO: support date format for metadata
+		site := NewSiteURL(sitecfg)
+		atomid := site.Abs(fmt.Sprintf("%s-%s-%s-%s",
 			matches[1], matches[2], matches[3], matches[4]))
-		if err != nil {
-			return nil, err
-		}
 
 		datetime, err := time.Parse("2006 01 02",
 			fmt.Sprintf("%s %s %s", matches[1], matches[2],
@@ -112,15 +157,29 @@ func GeneratePost(sitecfg, cfg M, info ContentInfo) (Content, error) {
 			return nil, err
 		}
 
-		return &Post{
+		p := &Post{
 			HTMLDocument: &HTMLDocument{ContentInfo: info},
 			datetime:     datetime,
 			date: fmt.Sprintf("%s-%s-%s",
 				matches[1], matches[2], matches[3]),
 			xmldate: XMLDate(datetime),
-			url:     url,
 			atomid:  atomid,
-		}, nil
+			site:    site,
+			vars: map[string]string{
+				"year":  matches[1],
+				"month": matches[2],
+				"day":   matches[3],
+				"slug":  matches[4],
+				"title": matches[4],
+			},
+			draft: draft,
+			excerptSep: cfg.String("excerpt_separator",
+				sitecfg.String("excerpt_separator", defaultExcerptSeparator)),
+			summaryWords: cfg.Int("summary_words",
+				sitecfg.Int("summary_words", defaultSummaryWords)),
+		}
+		p.setPermalink(cfg.String("permalink", defaultPostPermalink))
+		return p, nil
 	default:
 		return nil, ErrIgnore
 	}
@@ -129,4 +188,9 @@ func GeneratePost(sitecfg, cfg M, info ContentInfo) (Content, error) {
 
 func init() {
 	RegisterGenerator("post", GeneratePost)
+	RegisterGeneratorConfig("post", map[string]string{
+		"permalink":         "string",
+		"excerpt_separator": "string",
+		"summary_words":     "int",
+	})
 }
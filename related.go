@@ -0,0 +1,122 @@
+package grout
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var wordRE = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// relate sets the related metadata of every item to the other items
+// most like it, best first. Items sharing the most tags come first,
+// and those with as many tags in common are ranked by how similar
+// their text is, going by the TF-IDF weights of their words. Items
+// with nothing in common aren't related at all. limit caps how many
+// each item gets.
+//
+// Comparing every pair of items is slow for large collections, so it
+// only happens for collections that set related_limit:
+//
+//	collections:
+//	  posts:
+//	    related_limit: 5
+func relate(items []M, limit int) {
+	tags := make([]map[string]bool, len(items))
+	vecs := make([]map[string]float64, len(items))
+	df := make(map[string]int)
+	for i, item := range items {
+		tags[i] = make(map[string]bool)
+		for _, t := range termsOf(item["tags"]) {
+			tags[i][strings.ToLower(t)] = true
+		}
+		vecs[i] = make(map[string]float64)
+		text := item.String("title", "") + " " + stripHTML(item.String("content", ""))
+		for _, w := range wordRE.FindAllString(strings.ToLower(text), -1) {
+			if vecs[i][w] == 0 {
+				df[w]++
+			}
+			vecs[i][w]++
+		}
+	}
+	for _, vec := range vecs {
+		var norm float64
+		for w, tf := range vec {
+			vec[w] = tf * math.Log(float64(len(items))/float64(df[w]))
+			norm += vec[w] * vec[w]
+		}
+		norm = math.Sqrt(norm)
+		for w := range vec {
+			if norm > 0 {
+				vec[w] /= norm
+			}
+		}
+	}
+
+	for i, item := range items {
+		var ranked []relatedItem
+		for j := range items {
+			if i == j {
+				continue
+			}
+			r := relatedItem{index: j}
+			for t := range tags[i] {
+				if tags[j][t] {
+					r.shared++
+				}
+			}
+			for w, x := range vecs[i] {
+				r.similarity += x * vecs[j][w]
+			}
+			if r.shared > 0 || r.similarity > 0 {
+				ranked = append(ranked, r)
+			}
+		}
+		sort.Stable(byRelevance(ranked))
+		if len(ranked) > limit {
+			ranked = ranked[:limit]
+		}
+		related := make([]M, 0, len(ranked))
+		for _, r := range ranked {
+			related = append(related, itemLink(items[r.index]))
+		}
+		item["related"] = related
+	}
+}
+
+// itemLink returns what it takes to link to a collection item: its
+// title, url, absurl and date. Items refer to each other this way,
+// rather than by their whole metadata, which would make it cyclic.
+func itemLink(item M) M {
+	link := make(M, 4)
+	for _, k := range []string{"title", "url", "absurl", "date"} {
+		if v, ok := item[k]; ok {
+			link[k] = v
+		}
+	}
+	return link
+}
+
+type relatedItem struct {
+	index      int
+	shared     int
+	similarity float64
+}
+
+type byRelevance []relatedItem
+
+func (r byRelevance) Len() int {
+	return len(r)
+}
+
+func (r byRelevance) Less(i, j int) bool {
+	if r[i].shared != r[j].shared {
+		return r[i].shared > r[j].shared
+	}
+	return r[i].similarity > r[j].similarity
+}
+
+func (r byRelevance) Swap(i, j int) {
+	r[i], r[j] = r[j], r[i]
+}
@@ -0,0 +1,512 @@
+package grout
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// schema describes what a config value may be.
+type schema struct {
+	// kinds the value may be, such as "int" or "bool|map". Empty
+	// means anything goes.
+	kinds string
+	// keys are the known keys of a map.
+	keys map[string]*schema
+	// values describes the value under any other key of a map. If
+	// it is nil, other keys are mistakes, unless open is set.
+	values *schema
+	// open maps may have keys of their own, such as the top level
+	// of the site config, which is also template data. Unknown keys
+	// are only warned about when they look like a typo.
+	open bool
+	// items describes every element of a list.
+	items *schema
+	// check replaces the usual checks of a value.
+	check func(c *configCheck, path []string, v interface{})
+}
+
+func kind(k string) *schema {
+	return &schema{kinds: k}
+}
+
+func mapOf(keys map[string]*schema) *schema {
+	return &schema{kinds: "map", keys: keys}
+}
+
+func mapEach(values *schema) *schema {
+	return &schema{kinds: "map", values: values}
+}
+
+// collectionConfig are the config keys every collection has, whatever
+// its generator.
+var collectionConfig = map[string]*schema{
+	"dir":           kind("string"),
+	"generator":     kind("string"),
+	"drafts_dir":    kind("string"),
+	"related_limit": kind("int"),
+}
+
+var generatorConfig = make(map[string]map[string]*schema)
+
+// RegisterGeneratorConfig declares the config keys a generator reads
+// from its collection's section of the site config, along with the
+// kind of each: "string", "int", "bool", "list", "map" or "" for
+// anything. Keys a collection sets that neither its generator nor grout
+// knows are reported as mistakes. Generators that declare nothing may
+// be given any keys.
+func RegisterGeneratorConfig(name string, keys map[string]string) {
+	if _, ok := generatorConfig[name]; ok {
+		log.Fatalf("Config for generator '%s' already exists!\n", name)
+	}
+	m := make(map[string]*schema, len(keys))
+	for k, v := range keys {
+		m[k] = kind(v)
+	}
+	generatorConfig[name] = m
+}
+
+// siteSchema describes the site config.
+var siteSchema = &schema{
+	kinds: "map",
+	open:  true,
+	keys: map[string]*schema{
+		"url":               kind("string"),
+		"baseurl":           kind("string"),
+		"title":             kind("string"),
+		"description":       kind("string"),
+		"author":            kind(""),
+		"excerpt_separator": kind("string"),
+		"summary_words":     kind("int"),
+		"collections":       {kinds: "map", check: checkCollections},
+		"content_types":     mapEach(kind("string")),
+		"markdown": mapOf(map[string]*schema{
+			"smartypants": kind("bool"),
+			"footnotes":   kind("bool"),
+			"tables":      kind("bool"),
+			"header_ids":  kind("bool"),
+		}),
+		"taxonomies": mapEach(mapOf(map[string]*schema{
+			"layout": kind("string"),
+			"path":   kind("string"),
+		})),
+		"archives": mapOf(map[string]*schema{
+			"collection": kind("string"),
+			"layout":     kind("string"),
+			"yearly":     kind("string"),
+			"monthly":    kind("string"),
+		}),
+		"feeds": mapEach(mapOf(map[string]*schema{
+			"collection":  kind("string"),
+			"taxonomy":    kind("string"),
+			"format":      kind("string"),
+			"path":        kind("string"),
+			"limit":       kind("int"),
+			"title":       kind("string"),
+			"description": kind("string"),
+		})),
+		"sitemap": {kinds: "bool|map", keys: map[string]*schema{
+			"path":   kind("string"),
+			"limit":  kind("int"),
+			"robots": kind("bool"),
+		}},
+		"defaults": {kinds: "list", items: mapOf(map[string]*schema{
+			"scope": mapOf(map[string]*schema{
+				"path":       kind("string"),
+				"collection": kind("string"),
+				"type":       kind("string"),
+			}),
+			"values": kind("map"),
+		})},
+	},
+}
+
+func init() {
+	// environments override any part of the config
+	siteSchema.keys["environments"] = mapEach(siteSchema)
+}
+
+// configLayer is one of the layers the site config is merged from.
+type configLayer struct {
+	// file the layer was read from, or "environment" for GROUT_
+	// variables, and its contents, used to find the line of a key
+	file string
+	raw  []byte
+	cfg  M
+	// prefix is where cfg is found in the file, for the block of an
+	// environment
+	prefix []string
+}
+
+// configCheck gathers the problems with the site config.
+type configCheck struct {
+	layers   []configLayer
+	cfg      M
+	errs     []*ConfigError
+	warnings []*ConfigError
+}
+
+// checkConfig reports the mistakes in cfg, which was merged from
+// layers. Each mistake is blamed on the last layer to set its key.
+// Values of the wrong kind, unknown keys of sections grout reads and
+// collections it can't generate are errors. Unknown keys at the top
+// level are only warnings, and only when they look like a typo of a
+// known key, since the rest of the site config is free for templates.
+// The block for env is left out of the environments section, as it
+// has already been merged into cfg and is checked there.
+func checkConfig(layers []configLayer, cfg M, env string) *configCheck {
+	c := &configCheck{layers: layers, cfg: cfg}
+	if envs := cfg.Map("environments"); envs != nil && env != "" {
+		others := make(M, len(envs))
+		for k, v := range envs {
+			if k != env {
+				others[k] = v
+			}
+		}
+		cfg = cfg.With("environments", others)
+	}
+	siteSchema.checkValue(c, nil, cfg)
+	return c
+}
+
+func (s *schema) checkValue(c *configCheck, path []string, v interface{}) {
+	if v == nil {
+		return
+	}
+	if s.check != nil {
+		s.check(c, path, v)
+		return
+	}
+	k := kindOf(v)
+	if s.kinds != "" && !hasKind(s.kinds, k) {
+		c.errorf(path, "should be %s, not %s", article(s.kinds), article(k))
+		return
+	}
+	switch v := v.(type) {
+	case M:
+		s.checkMap(c, path, v)
+	case []interface{}:
+		if s.items == nil {
+			return
+		}
+		for i, item := range v {
+			s.items.checkValue(c, subPath(path, fmt.Sprintf("[%d]", i)), item)
+		}
+	}
+}
+
+func (s *schema) checkMap(c *configCheck, path []string, m M) {
+	if s.keys == nil && s.values == nil {
+		return
+	}
+	for _, k := range sortedKeys(m) {
+		p := subPath(path, k)
+		if ks, ok := s.keys[k]; ok {
+			ks.checkValue(c, p, m[k])
+			continue
+		}
+		if s.values != nil {
+			s.values.checkValue(c, p, m[k])
+			continue
+		}
+		guess := closest(k, s.keys)
+		switch {
+		case !s.open:
+			c.errorf(p, "unknown key%s", didYouMean(guess))
+		case guess != "":
+			c.warnf(p, "unknown key%s", didYouMean(guess))
+		}
+	}
+}
+
+// checkCollections checks each collection against the config keys of
+// its generator.
+func checkCollections(c *configCheck, path []string, v interface{}) {
+	m, ok := v.(M)
+	if !ok {
+		c.errorf(path, "should be a map, not %s", article(kindOf(v)))
+		return
+	}
+	for _, name := range sortedKeys(m) {
+		p := subPath(path, name)
+		props, ok := m[name].(M)
+		if !ok {
+			c.errorf(p, "should be a map, not %s", article(kindOf(m[name])))
+			continue
+		}
+		// a collection in an environment block may leave its generator
+		// to the top level
+		gen := props.String("generator",
+			c.cfg.Map("collections/"+name).String("generator", "post"))
+		if _, ok := generators[gen]; !ok {
+			names := make(map[string]*schema, len(generators))
+			for g := range generators {
+				names[g] = nil
+			}
+			c.errorf(subPath(p, "generator"), "no generator %q is registered%s",
+				gen, didYouMean(closest(gen, names)))
+			continue
+		}
+		collectionSchema(gen).checkValue(c, p, props)
+	}
+}
+
+// collectionSchema describes the config of a collection made by the
+// generator gen.
+func collectionSchema(gen string) *schema {
+	keys, ok := generatorConfig[gen]
+	if !ok {
+		s := mapOf(collectionConfig)
+		s.open = true
+		return s
+	}
+	s := mapOf(make(map[string]*schema, len(collectionConfig)+len(keys)))
+	for k, ks := range collectionConfig {
+		s.keys[k] = ks
+	}
+	for k, ks := range keys {
+		s.keys[k] = ks
+	}
+	return s
+}
+
+// schemaAt returns the schema of the config value at path, or nil if
+// grout doesn't know the key. cfg is the config read so far, which says
+// what generates each collection.
+func schemaAt(path []string, cfg M) *schema {
+	collections := siteSchema.keys["collections"]
+	s := siteSchema
+	for _, k := range path {
+		switch {
+		case s == collections:
+			s = collectionSchema(cfg.Map("collections/"+k).String("generator", "post"))
+		case s.keys[k] != nil:
+			s = s.keys[k]
+		case s.values != nil:
+			s = s.values
+		default:
+			return nil
+		}
+	}
+	return s
+}
+
+// report prints the warnings and every error but the first, which it
+// returns.
+func (c *configCheck) report() error {
+	for _, w := range c.warnings {
+		fmt.Printf("warning: %v\n", w)
+	}
+	if len(c.errs) == 0 {
+		return nil
+	}
+	for _, e := range c.errs[1:] {
+		fmt.Println(e)
+	}
+	return c.errs[0]
+}
+
+func (c *configCheck) errorf(path []string, format string, args ...interface{}) {
+	c.errs = append(c.errs, c.problem(path, format, args...))
+}
+
+func (c *configCheck) warnf(path []string, format string, args ...interface{}) {
+	c.warnings = append(c.warnings, c.problem(path, format, args...))
+}
+
+func (c *configCheck) problem(path []string, format string, args ...interface{}) *ConfigError {
+	file, line := c.locate(path)
+	return &ConfigError{
+		File: file,
+		Line: line,
+		Err:  fmt.Errorf("%q "+format, append([]interface{}{keyPath(path)}, args...)...),
+	}
+}
+
+// locate returns the file and line of the last layer to set the key at
+// path. Keys no layer sets, such as a generator a collection gets by
+// default, are blamed on the layer setting the nearest key above them.
+func (c *configCheck) locate(path []string) (string, int) {
+	for n := len(path); n > 0; n-- {
+		for i := len(c.layers) - 1; i >= 0; i-- {
+			l := c.layers[i]
+			if hasKey(l.cfg, path[:n]) {
+				return l.file, keyLine(l.raw, append(append([]string(nil), l.prefix...), path[:n]...))
+			}
+		}
+	}
+	if len(c.layers) > 0 {
+		return c.layers[0].file, 0
+	}
+	return "", 0
+}
+
+// hasKey reports whether cfg has a value at path. Lists are taken to
+// have every element.
+func hasKey(cfg M, path []string) bool {
+	var v interface{} = cfg
+	for _, k := range path {
+		switch m := v.(type) {
+		case M:
+			var ok bool
+			if v, ok = m[k]; !ok {
+				return false
+			}
+		case []interface{}:
+			return true
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// subPath returns path with k on the end, leaving path untouched.
+func subPath(path []string, k string) []string {
+	return append(append([]string(nil), path...), k)
+}
+
+func keyPath(path []string) string {
+	s := strings.Join(path, "/")
+	return strings.Replace(s, "/[", "[", -1)
+}
+
+// keyLine finds the line of the key at path in a YAML, TOML or JSON
+// file, by looking for each key of the path in turn after the line of
+// the one before. It is a guess that holds for ordinary config files,
+// and is 0 when nothing was found.
+func keyLine(raw []byte, path []string) int {
+	lines := strings.Split(string(raw), "\n")
+	line := -1
+	for _, k := range path {
+		if strings.HasPrefix(k, "[") {
+			continue
+		}
+		q := regexp.QuoteMeta(k)
+		// key: or - key: in YAML, key = or [a.key] in TOML, "key": in
+		// JSON, which may follow a { or , on the line
+		re := regexp.MustCompile(`(^|[\s{,\-])"?` + q + `"?\s*[:=]|^\s*\[+(.*\.)?` + q + `\]`)
+		next := -1
+		for i := line; i < len(lines); i++ {
+			if i >= 0 && re.MatchString(lines[i]) {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			return 0
+		}
+		line = next
+	}
+	return line + 1
+}
+
+func kindOf(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case int, int64:
+		return "int"
+	case float64:
+		if v == float64(int64(v)) {
+			return "int"
+		}
+		return "float"
+	case []interface{}:
+		return "list"
+	case M:
+		return "map"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func hasKind(kinds, k string) bool {
+	for _, want := range strings.Split(kinds, "|") {
+		if want == k || want == "float" && k == "int" {
+			return true
+		}
+	}
+	return false
+}
+
+// article puts "a" or "an" before kinds, such as "an int" or "a bool
+// or map".
+func article(kinds string) string {
+	s := strings.Replace(kinds, "|", " or ", -1)
+	if strings.IndexAny(s[:1], "aeiou") == 0 {
+		return "an " + s
+	}
+	return "a " + s
+}
+
+func didYouMean(guess string) string {
+	if guess == "" {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %q?", guess)
+}
+
+// closest returns the key most like k, if one is near enough to be a
+// likely typo.
+func closest(k string, keys map[string]*schema) string {
+	best, bestDist := "", 3
+	for _, known := range sortedSchemaKeys(keys) {
+		if d := editDistance(k, known); d < bestDist && d < len(k) {
+			best, bestDist = known, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+func sortedKeys(m M) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedSchemaKeys(m map[string]*schema) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
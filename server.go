@@ -0,0 +1,179 @@
+package grout
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// reloadPath is where the dev server streams reload events.
+const reloadPath = "/_grout/reload"
+
+// reloadScript is injected into every HTML page served while
+// live-reload is on. A "css" event swaps stylesheets in place, anything
+// else reloads the page.
+const reloadScript = `<script>
+(function() {
+	if (!window.EventSource) return;
+	var es = new EventSource("` + reloadPath + `");
+	es.onmessage = function(e) {
+		if (e.data !== "css") {
+			location.reload();
+			return;
+		}
+		var links = document.querySelectorAll('link[rel="stylesheet"]');
+		for (var i = 0; i < links.length; i++) {
+			var href = links[i].href.replace(/[?&]_grout=[0-9]+$/, "");
+			links[i].href = href + (href.indexOf("?") < 0 ? "?" : "&") +
+				"_grout=" + Date.now();
+		}
+	};
+})();
+</script>
+`
+
+// Serve hosts the built site in dir over HTTP on opt.HttpHost. It
+// blocks until the server fails.
+func Serve(dir string, opt *Options) error {
+	return newServer(dir, false).listen(opt)
+}
+
+type server struct {
+	files      http.Handler
+	liveReload bool
+
+	mu      sync.Mutex
+	clients map[chan string]bool
+}
+
+func newServer(dir string, liveReload bool) *server {
+	return &server{
+		files:      http.FileServer(http.Dir(dir)),
+		liveReload: liveReload,
+		clients:    make(map[chan string]bool),
+	}
+}
+
+func (s *server) listen(opt *Options) error {
+	fmt.Printf("HTTP server listening on %s\n", opt.HttpHost)
+	return http.ListenAndServe(opt.HttpHost, s)
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.liveReload {
+		s.files.ServeHTTP(w, r)
+		return
+	}
+	if r.URL.Path == reloadPath {
+		s.serveEvents(w, r)
+		return
+	}
+
+	// Always send the full page so the script can be injected.
+	r.Header.Del("If-Modified-Since")
+	r.Header.Del("If-None-Match")
+	bw := &bufferedResponse{header: make(http.Header), status: http.StatusOK}
+	s.files.ServeHTTP(bw, r)
+
+	body := bw.body.Bytes()
+	if strings.HasPrefix(bw.header.Get("Content-Type"), "text/html") {
+		body = injectScript(body, reloadScript)
+		bw.header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	for k, v := range bw.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(bw.status)
+	w.Write(body)
+}
+
+func (s *server) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher.Flush()
+
+	c := make(chan string, 1)
+	s.mu.Lock()
+	s.clients[c] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, c)
+		s.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case msg := <-c:
+			fmt.Fprintf(w, "data: %s\n\n", msg)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// reload tells every connected browser to refresh. If only
+// stylesheets changed, they are swapped without a full reload.
+func (s *server) reload(changed []string) {
+	msg := "reload"
+	if len(changed) > 0 {
+		msg = "css"
+		for _, path := range changed {
+			if filepath.Ext(path) != ".css" {
+				msg = "reload"
+				break
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.clients {
+		select {
+		case c <- msg:
+		default:
+			// A reload is already pending for this client.
+		}
+	}
+}
+
+// injectScript inserts script just before </body>, or appends it if
+// the page has no closing body tag.
+func injectScript(page []byte, script string) []byte {
+	i := bytes.LastIndex(bytes.ToLower(page), []byte("</body>"))
+	if i < 0 {
+		return append(page, script...)
+	}
+	out := make([]byte, 0, len(page)+len(script))
+	out = append(out, page[:i]...)
+	out = append(out, script...)
+	return append(out, page[i:]...)
+}
+
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	b.status = status
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
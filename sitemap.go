@@ -0,0 +1,241 @@
+package grout
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxSitemapURLs is the most URLs the sitemap protocol allows in one
+// file. Sites with more get a sitemap index.
+const maxSitemapURLs = 50000
+
+// sitemap makes sitemap.xml and robots.txt from every page written to
+// the site. It can be tuned in the site config:
+//
+//	sitemap:
+//	  path: /sitemap.xml
+//	  limit: 50000
+//	  robots: true
+//
+// or turned off altogether with "sitemap: false". A page is left out
+// when its front matter says "sitemap: false". Pages get their lastmod
+// from front matter lastmod or date, falling back to the modification
+// time of their source file. A sitemap.xml or robots.txt among the
+// site's own files always wins over the generated one. It must run
+// after everything else that adds content.
+func (b *builder) sitemap(content []Content, collections []collection) []Content {
+	if !b.cfg.Bool("sitemap", true) {
+		return nil
+	}
+	cfg := b.cfg.Map("sitemap")
+	site := NewSiteURL(b.cfg)
+	file := strings.TrimPrefix(cfg.String("path", "/sitemap.xml"), "/")
+	limit := cfg.Int("limit", maxSitemapURLs)
+	if limit < 1 || limit > maxSitemapURLs {
+		limit = maxSitemapURLs
+	}
+
+	all := append([]Content(nil), content...)
+	for _, c := range collections {
+		all = append(all, c.content...)
+	}
+	var urls []sitemapURL
+	exists := make(map[string]bool)
+	for _, c := range all {
+		p := filepath.ToSlash(c.Path())
+		exists[p] = true
+		if c.IsDir() || !isPage(p) {
+			continue
+		}
+		var fm M
+		if f, ok := c.(frontMattered); ok {
+			fm = f.frontMatter()
+		}
+		if !fm.Bool("sitemap", true) {
+			continue
+		}
+		u := sitemapURL{Loc: site.Abs(pageLink(p))}
+		if t := lastmod(c, fm); !t.IsZero() {
+			u.LastMod = XMLDate(t)
+		}
+		urls = append(urls, u)
+	}
+	if exists[file] {
+		return nil
+	}
+	sort.Sort(sitemapURLs(urls))
+
+	var out []Content
+	if len(urls) <= limit {
+		out = append(out, &sitemapFile{generated{file}, urls})
+	} else {
+		index := &sitemapIndex{generated: generated{file}}
+		ext := path.Ext(file)
+		for i, n := 0, 1; i < len(urls); i, n = i+limit, n+1 {
+			part := fmt.Sprintf("%s-%d%s", strings.TrimSuffix(file, ext), n, ext)
+			end := i + limit
+			if end > len(urls) {
+				end = len(urls)
+			}
+			out = append(out, &sitemapFile{generated{part}, urls[i:end]})
+			index.sitemaps = append(index.sitemaps, site.Abs(part))
+		}
+		out = append(out, index)
+	}
+	if cfg.Bool("robots", true) && !exists["robots.txt"] {
+		out = append(out, &robotsFile{generated{"robots.txt"}, site.Abs(file)})
+	}
+	return out
+}
+
+// frontMattered is implemented by content with front matter, such as
+// an HTMLDocument or anything embedding one.
+type frontMattered interface {
+	frontMatter() M
+}
+
+func (d *HTMLDocument) frontMatter() M {
+	return d.FrontMatter
+}
+
+// isPage reports whether the file at p is a page that belongs in the
+// sitemap, rather than a stylesheet, feed or image.
+func isPage(p string) bool {
+	ext := path.Ext(p)
+	return ext == ".html" || ext == ".htm"
+}
+
+// pageLink returns the link to the page at p, leaving off index.html.
+func pageLink(p string) string {
+	if path.Base(p) == "index.html" {
+		return dirLink(path.Dir(p))
+	}
+	return "/" + p
+}
+
+// lastmod returns when c was last changed, or the zero time if that
+// isn't known.
+func lastmod(c Content, fm M) time.Time {
+	for _, k := range []string{"lastmod", "date"} {
+		if t, ok := parseDate(fm.String(k, "")); ok {
+			return t
+		}
+	}
+	if c.FullPath() == "" {
+		return time.Time{}
+	}
+	if info, err := os.Stat(c.FullPath()); err == nil {
+		return info.ModTime()
+	}
+	return time.Time{}
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapURLs []sitemapURL
+
+func (s sitemapURLs) Len() int           { return len(s) }
+func (s sitemapURLs) Less(i, j int) bool { return s[i].Loc < s[j].Loc }
+func (s sitemapURLs) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapIndexSet struct {
+	XMLName  xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 sitemapindex"`
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+// generated holds what every generated file without a source has in
+// common.
+type generated struct {
+	path string
+}
+
+func (g generated) IsDir() bool {
+	return false
+}
+
+func (g generated) FullPath() string {
+	return ""
+}
+
+func (g generated) Path() string {
+	return g.path
+}
+
+func (g generated) Read(data M) error {
+	return nil
+}
+
+func (g generated) create(dir string) (*os.File, error) {
+	p := filepath.Join(dir, g.path)
+	err := os.MkdirAll(filepath.Dir(p), 0700)
+	if err != nil {
+		return nil, err
+	}
+	return os.Create(p)
+}
+
+// sitemapFile is a sitemap.xml listing urls.
+type sitemapFile struct {
+	generated
+	urls []sitemapURL
+}
+
+func (s *sitemapFile) Write(dir, cachedir string, data M) error {
+	f, err := s.create(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return writeXML(f, sitemapURLSet{URLs: s.urls})
+}
+
+// sitemapIndex points at the sitemap files a large site is split into.
+type sitemapIndex struct {
+	generated
+	sitemaps []string
+}
+
+func (s *sitemapIndex) Write(dir, cachedir string, data M) error {
+	f, err := s.create(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	set := sitemapIndexSet{}
+	for _, loc := range s.sitemaps {
+		set.Sitemaps = append(set.Sitemaps, sitemapURL{Loc: loc})
+	}
+	return writeXML(f, set)
+}
+
+// robotsFile is a robots.txt allowing everything and pointing crawlers
+// at the sitemap.
+type robotsFile struct {
+	generated
+	sitemap string
+}
+
+func (r *robotsFile) Write(dir, cachedir string, data M) error {
+	f, err := r.create(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.WriteString(f, "User-agent: *\nDisallow:\n\nSitemap: "+r.sitemap+"\n")
+	return err
+}
@@ -0,0 +1,130 @@
+package grout
+
+import (
+	"sort"
+	"strings"
+)
+
+// defaultTaxonomyPath is where term pages go unless the taxonomy's
+// config says otherwise.
+const defaultTaxonomyPath = "/:taxonomy/:term/"
+
+// taxonomies groups collection items by the terms in their front
+// matter, for every taxonomy in the site config:
+//
+//	taxonomies:
+//	  tags:
+//	    layout: tag
+//	    path: /tags/:term/
+//	  categories:
+//	    layout: category
+//
+// Each taxonomy becomes a map in data from term to an object with its
+// name, slug, url, count and items. If the taxonomy has a layout, a
+// page is made for every term, returned as content to be written.
+func (b *builder) taxonomies(collections []collection, data M) []Content {
+	cfg := b.cfg.Map("taxonomies")
+	if cfg == nil {
+		return nil
+	}
+
+	names := make([]string, 0, len(collections))
+	for _, c := range collections {
+		names = append(names, c.name)
+	}
+	sort.Strings(names)
+
+	site := NewSiteURL(b.cfg)
+	var pages []Content
+	for taxonomy, iprops := range cfg {
+		props, _ := iprops.(M)
+		pattern := props.String("path", defaultTaxonomyPath)
+		layout := props.String("layout", "")
+
+		// Terms that only differ in case or punctuation, such as Go and
+		// go, share a slug and so a page. They are one term, named as
+		// it was first seen.
+		terms := make(M)
+		bySlug := make(map[string]M)
+		files := make(map[string]string)
+		for _, name := range names {
+			items, _ := data[name].([]M)
+			for _, item := range items {
+				added := make(map[string]bool)
+				for _, term := range termsOf(item[taxonomy]) {
+					slug := Slugify(term)
+					t, ok := bySlug[slug]
+					if !ok {
+						file, link := Permalink(pattern, map[string]string{
+							"taxonomy": taxonomy,
+							"term":     slug,
+						})
+						t = M{
+							"name":  term,
+							"slug":  slug,
+							"url":   site.Rel(link),
+							"items": []M{},
+						}
+						terms[term] = t
+						bySlug[slug] = t
+						files[slug] = file
+					}
+					if !added[slug] {
+						added[slug] = true
+						t["items"] = append(t["items"].([]M), item)
+					}
+				}
+			}
+		}
+		for _, t := range terms {
+			t := t.(M)
+			t["count"] = len(t["items"].([]M))
+		}
+		data[taxonomy] = terms
+
+		if layout == "" {
+			continue
+		}
+		for term, t := range terms {
+			t := t.(M)
+			pages = append(pages, &layoutPage{
+				path:   files[t["slug"].(string)],
+				layout: layout,
+				page: M{
+					"title":    term,
+					"url":      t["url"],
+					"taxonomy": taxonomy,
+					"term":     t,
+				},
+				templates: b.templates,
+			})
+		}
+	}
+	return pages
+}
+
+// termsOf returns the terms in a front matter value, which may be a
+// list or a string of terms separated by commas or spaces.
+func termsOf(v interface{}) []string {
+	var terms []string
+	switch v := v.(type) {
+	case []interface{}:
+		for _, t := range v {
+			if s, ok := t.(string); ok && s != "" {
+				terms = append(terms, s)
+			}
+		}
+	case string:
+		sep := " "
+		if strings.Contains(v, ",") {
+			sep = ","
+		}
+		for _, t := range strings.Split(v, sep) {
+			t = strings.TrimSpace(t)
+			if t != "" {
+				terms = append(terms, t)
+			}
+		}
+	}
+	return terms
+}
@@ -1,28 +1,37 @@
 package grout
 
 import (
-	"github.com/james4k/fmatter"
 	"os"
 	"path/filepath"
 	"text/template"
+	"text/template/parse"
 )
 
 type TextDocument struct {
 	ContentInfo
 	FrontMatter M
 	Template    *template.Template
+	lineOffset  int
+	templates   *siteTemplates
+
+	// Defaults are front matter values the document has unless its
+	// own front matter sets them.
+	Defaults M
 }
 
 func (d *TextDocument) Read(data M) error {
 	var err error
 	d.FrontMatter = make(M, 8)
-	content, err := fmatter.ReadFile(d.FullPath(), d.FrontMatter)
+	content, offset, err := readFrontMatter(d.FullPath(), d.FrontMatter)
 	if err != nil {
 		return err
 	}
+	d.lineOffset = offset
+	mergeDefaults(d.FrontMatter, d.Defaults)
 
-	d.Template, err = template.New(d.Path()).Parse(string(content))
-	return err
+	t := template.New(d.Path()).Funcs(template.FuncMap(templateFuncs(data)))
+	d.Template, err = d.templates.addIncludes(t).Parse(string(content))
+	return d.WrapTemplateError(err)
 }
 
 func (d *TextDocument) Write(dir, cachedir string, data M) error {
@@ -32,9 +41,32 @@ func (d *TextDocument) Write(dir, cachedir string, data M) error {
 	}
 	defer newf.Close()
 
-	data["page"] = d.FrontMatter
-	err = d.Template.Execute(newf, data)
-	delete(data, "page")
+	err = d.Template.Execute(newf, data.With("page", d.FrontMatter))
+	return d.WrapTemplateError(err)
+}
+
+// WrapTemplateError wraps an error from parsing or executing
+// d.Template as a *TemplateError with a line number relative to the
+// start of the file.
+func (d *TextDocument) WrapTemplateError(err error) error {
+	return templateError(d.FullPath(), d.Path(), d.lineOffset, err)
+}
+
+func (d *TextDocument) templateTrees() []*parse.Tree {
+	var trees []*parse.Tree
+	for _, t := range d.Template.Templates() {
+		trees = append(trees, t.Tree)
+	}
+	return trees
+}
 
-	return err
+func (d *TextDocument) layoutName() string {
+	return ""
+}
+
+func (d *TextDocument) extraDataKeys() []string {
+	if d.Defaults != nil {
+		return []string{"defaults"}
+	}
+	return nil
 }
@@ -0,0 +1,68 @@
+package grout
+
+import (
+	"net/url"
+	"strings"
+)
+
+// SiteURL builds links from the site's url and baseurl config. url is
+// the absolute address of the site, such as "http://example.com", and
+// is used for feeds and canonical links. baseurl is the path the site
+// is hosted under, such as "/blog". If baseurl isn't set, the path of
+// url is used instead.
+type SiteURL struct {
+	Root string
+	Base string
+}
+
+func NewSiteURL(sitecfg M) SiteURL {
+	var s SiteURL
+	base := sitecfg.String("baseurl", "")
+	u, err := url.Parse(sitecfg.String("url", ""))
+	if err == nil {
+		if u.Scheme != "" && u.Host != "" {
+			s.Root = u.Scheme + "://" + u.Host
+		}
+		if base == "" {
+			base = u.Path
+		}
+	}
+	s.Base = strings.TrimSuffix(base, "/")
+	if s.Base != "" && !strings.HasPrefix(s.Base, "/") {
+		s.Base = "/" + s.Base
+	}
+	return s
+}
+
+// Rel returns the link to path on the site, including the base path,
+// such as "/blog/about.html". Absolute URLs are returned untouched.
+func (s SiteURL) Rel(path string) string {
+	if isAbsURL(path) {
+		return path
+	}
+	return s.Base + "/" + strings.TrimPrefix(path, "/")
+}
+
+// Abs returns the absolute URL of path on the site, such as
+// "http://example.com/blog/about.html". Without a site url, it is
+// the same as Rel.
+func (s SiteURL) Abs(path string) string {
+	if isAbsURL(path) {
+		return path
+	}
+	return s.Root + s.Rel(path)
+}
+
+func isAbsURL(path string) bool {
+	u, err := url.Parse(path)
+	return err == nil && u.Scheme != ""
+}
+
+// urlFuncs returns the absURL and relURL template functions.
+func urlFuncs(data M) map[string]interface{} {
+	s := NewSiteURL(data)
+	return map[string]interface{}{
+		"absURL": s.Abs,
+		"relURL": s.Rel,
+	}
+}
@@ -2,6 +2,9 @@ package grout
 
 import (
 	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"time"
 )
 
@@ -20,6 +23,36 @@ func BuildURL(root, path string) (string, error) {
 	return u.String(), nil
 }
 
+var slugRE = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+
+// Slugify lowercases s and replaces anything but letters and digits with
+// dashes, making it safe to use in a URL.
+func Slugify(s string) string {
+	return strings.Trim(slugRE.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
+
+// replaceExt returns path with its extension swapped for ext.
+func replaceExt(path, ext string) string {
+	return path[:len(path)-len(filepath.Ext(path))] + ext
+}
+
+// dateLayouts are the formats dates in front matter may be in.
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// parseDate parses s in any of the dateLayouts.
+func parseDate(s string) (time.Time, bool) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
 func XMLDate(t time.Time) string {
 	return t.Format("2006-01-02T15:04:05-07:00")
 }
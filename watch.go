@@ -0,0 +1,199 @@
+package grout
+
+import (
+	"fmt"
+	"github.com/howeyc/fsnotify"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// watchDelay is how long the input must be quiet before a rebuild
+// starts, so a burst of saves only triggers one build.
+const watchDelay = 250 * time.Millisecond
+
+// Run builds the site and then, depending on opt, keeps rebuilding it
+// as the input changes (AutoBuild) and serves it over HTTP (HttpHost).
+// It only returns early if the first build fails.
+func Run(input, output string, opt *Options) error {
+	result, err := Build(input, output, opt)
+	if err != nil {
+		return err
+	}
+
+	var srv *server
+	if opt.HttpHost != "" {
+		srv = newServer(result.Output, opt.AutoBuild)
+	}
+
+	errc := make(chan error, 2)
+	running := 0
+	if opt.AutoBuild {
+		running++
+		go func() {
+			errc <- Watch(input, output, opt, func(r *Result, err error) {
+				if srv != nil && err == nil {
+					srv.reload(r.Changed)
+				}
+			})
+		}()
+	}
+	if srv != nil {
+		running++
+		go func() {
+			errc <- srv.listen(opt)
+		}()
+	}
+	if running == 0 {
+		return nil
+	}
+	return <-errc
+}
+
+// Watch rebuilds the site whenever a file under input, or under any
+// collection dir, changes. Failed rebuilds are printed and leave the
+// previous output in place. After every rebuild, rebuilt is called
+// with the outcome if it is non-nil. Watch only returns if the file
+// system watcher fails.
+func Watch(input, output string, opt *Options, rebuilt func(*Result, error)) error {
+	return watch(input, output, opt, watchDelay, rebuilt, nil)
+}
+
+// watch is Watch with the quiet time to wait for before rebuilding,
+// which also returns once stop is closed.
+func watch(input, output string, opt *Options, delay time.Duration,
+	rebuilt func(*Result, error), stop <-chan struct{}) error {
+	if input == "" {
+		input = "."
+	}
+	if output == "" {
+		output = filepath.Join(input, "_site")
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	w := &siteWatcher{
+		Options: opt,
+		input:   input,
+		output:  output,
+		watcher: watcher,
+		watched: make(map[string]bool),
+	}
+	err = w.watchDirs()
+	if err != nil {
+		return err
+	}
+
+	var timer <-chan time.Time
+	var changed []string
+	for {
+		select {
+		case ev := <-watcher.Event:
+			if w.ignore(ev.Name) {
+				continue
+			}
+			if ev.IsDelete() || ev.IsRename() {
+				delete(w.watched, ev.Name)
+			}
+			if opt.Verbose {
+				fmt.Printf("changed: %s\n", ev.Name)
+			}
+			changed = append(changed, ev.Name)
+			timer = time.After(delay)
+		case err := <-watcher.Error:
+			return err
+		case <-stop:
+			return nil
+		case <-timer:
+			timer = nil
+			w.rebuild(changed, rebuilt)
+			changed = nil
+		}
+	}
+}
+
+type siteWatcher struct {
+	*Options
+	input   string
+	output  string
+	watcher *fsnotify.Watcher
+	watched map[string]bool
+}
+
+func (w *siteWatcher) rebuild(changed []string, rebuilt func(*Result, error)) {
+	start := time.Now()
+	result, err := Build(w.input, w.output, w.Options)
+	if err != nil {
+		fmt.Printf("rebuild failed: %v\n", err)
+	} else {
+		result.Changed = changed
+		if w.Verbose {
+			fmt.Printf("rebuilt in %v\n", time.Since(start))
+		}
+	}
+	if rebuilt != nil {
+		rebuilt(result, err)
+	}
+
+	// New directories or collections may have shown up.
+	err = w.watchDirs()
+	if err != nil {
+		fmt.Printf("watch error: %v\n", err)
+	}
+}
+
+// watchDirs adds a watch for every directory under the input and every
+// collection dir that isn't watched yet.
+func (w *siteWatcher) watchDirs() error {
+	roots := []string{w.input}
+	b := &builder{Options: w.Options}
+	if b.readConfig(w.input) == nil {
+		for _, c := range b.makeCollections() {
+			roots = append(roots, filepath.Join(w.input, c.Dir()))
+			if c.drafts && c.DraftsDir() != "" {
+				roots = append(roots, filepath.Join(w.input, c.DraftsDir()))
+			}
+		}
+	}
+
+	for _, root := range roots {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil || !info.IsDir() {
+				return nil
+			}
+			if path != root && w.ignore(path) {
+				return filepath.SkipDir
+			}
+			if w.watched[path] {
+				return nil
+			}
+			err = w.watcher.Watch(path)
+			if err != nil {
+				return err
+			}
+			w.watched[path] = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ignore reports whether a change to path should not cause a rebuild,
+// either because it is build output or an editor's scratch file.
+func (w *siteWatcher) ignore(path string) bool {
+	name := filepath.Base(path)
+	if name[0] == '.' || strings.HasSuffix(name, "~") ||
+		strings.HasPrefix(name, "_tmpsite_") {
+		return true
+	}
+	return isWithin(w.output, path) ||
+		isWithin(cacheDir(w.input, w.Options), path)
+}